@@ -1,6 +1,16 @@
 package authorities
 
+import "time"
+
 type TokenHandler interface {
 	GenerateToken(auth *Authorized) (string, error)
 	ParseToken(token string) (*Authorized, error)
 }
+
+// TokenStore is implemented by the token handlers keeping the tokens, such as the redis one,
+// whose tokens can be renewed and revoked before they expire.
+type TokenStore interface {
+	// RenewToken sets the remaining lifetime of the token
+	RenewToken(token string, timeout time.Duration) error
+	RevokeToken(token string) error
+}
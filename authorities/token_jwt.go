@@ -125,15 +125,12 @@ func (m *jwtTokenHandler) ParseToken(token string) (*Authorized, error) {
 		return nil, err
 	}
 
-	var principal Principal
-	if err := json.Unmarshal(claims.Principal, &principal); err != nil {
+	// the claim holds the whole Authorized, so that the token can be generated again from it
+	authorized := &Authorized{}
+	if err := json.Unmarshal(claims.Principal, authorized); err != nil {
 		return nil, err
 	}
-
-	authorized := &Authorized{
-		ID:        ID(claims.ID),
-		Principal: principal,
-	}
+	authorized.ID = ID(claims.ID)
 	if len(claims.Audience) > 0 {
 		authorized.Account = claims.Audience[0]
 	}
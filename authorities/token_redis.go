@@ -3,6 +3,7 @@ package authorities
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -50,3 +51,25 @@ func (r *redisTokenHandler) ParseToken(token string) (*Authorized, error) {
 
 	return &authorized, nil
 }
+
+// RenewToken sets the remaining lifetime of the token
+func (r *redisTokenHandler) RenewToken(token string, timeout time.Duration) error {
+	ok, err := r.redis.Expire(context.Background(), token, timeout).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+// RevokeToken deletes the token
+func (r *redisTokenHandler) RevokeToken(token string) error {
+	return r.redis.Del(context.Background(), token).Err()
+}
+
+// Ping checks the connectivity of the redis client used for token storage
+func (r *redisTokenHandler) Ping(ctx context.Context) error {
+	return r.redis.Ping(ctx).Err()
+}
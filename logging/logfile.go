@@ -49,10 +49,13 @@ func (l *LogFile) Write(b []byte) (n int, err error) {
 }
 
 func (l *LogFile) Close() {
+	l.acquire.Lock()
+	defer l.acquire.Unlock()
 	if l.File == nil {
 		return
 	}
 
+	l.File.Sync()
 	l.File.Close()
 }
 
@@ -28,6 +28,7 @@ type Logger struct {
 	files  []*LogFile
 	hclog.InterceptLogger
 	doneCh chan struct{}
+	once   sync.Once
 }
 
 // NewLogger 日志文件初始化方法，如有需要请自己实现日志轮转
@@ -67,10 +68,15 @@ func NewLogger(app string, option *option.Log) (*Logger, error) {
 	return logging, nil
 }
 
+// Cleanup stops the rotation and closes the files, it may be called more than once.
+// The channel is closed but kept, the rotation goroutine is still reading it.
 func (l *Logger) Cleanup() {
-	l.close()
-	close(l.doneCh)
-	l.doneCh = nil
+	l.once.Do(func() {
+		close(l.doneCh)
+		l.Lock()
+		defer l.Unlock()
+		l.close()
+	})
 }
 
 func (l *Logger) Writer(opts ...*hclog.StandardLoggerOptions) io.Writer {
@@ -80,6 +86,32 @@ func (l *Logger) Writer(opts ...*hclog.StandardLoggerOptions) io.Writer {
 	return l.InterceptLogger.StandardWriter(&hclog.StandardLoggerOptions{})
 }
 
+// NewFileLogger creates a logger writing to a dedicated file {app}_{name}.log in the log path,
+// the file is rotated and closed with the files of the logger.
+func (l *Logger) NewFileLogger(name string) (hclog.Logger, error) {
+	logfile := &LogFile{
+		name:           filepath.Join(l.option.Path, l.app+"_"+name),
+		fileExt:        ".log",
+		rotationPolicy: rotationPolicy(l.option.Rotate),
+		acquire:        sync.Mutex{},
+	}
+	if err := logfile.openNew(); err != nil {
+		return nil, err
+	}
+
+	l.Lock()
+	l.files = append(l.files, logfile)
+	l.Unlock()
+
+	return hclog.New(&hclog.LoggerOptions{
+		Name:               name,
+		Output:             logfile,
+		Level:              hclog.Trace,
+		JSONFormat:         l.option.Format == "json",
+		JSONEscapeDisabled: true,
+	}), nil
+}
+
 func (l *Logger) start() {
 	next := l.nextRoundOfMilliDuration()
 	timer := time.NewTimer(next)
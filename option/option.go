@@ -7,6 +7,7 @@
 package option
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 
@@ -14,14 +15,146 @@ import (
 )
 
 type Http struct {
-	Path         string `long:"http.path" default:"" description:"Path for the HTTP server context" `
-	Address      string `long:"http.address" default:"0.0.0.0" description:"Address for the HTTP server listening" `
-	Port         int    `long:"http.port" default:"8080" description:"Port for the HTTP server listening" `
-	Cors         bool   `long:"http.cors" description:"Support CORS access" `
-	Trace        bool   `long:"http.trace" description:"Trace HTTP requests" `
-	IdleTimeout  int    `long:"http.idle" default:"0" description:"Timeout (in seconds) for idle connection" `
-	ReadTimeout  int    `long:"http.read" default:"0" description:"Timeout (in seconds) for reading client request" `
-	WriteTimeout int    `long:"http.write" default:"0" description:"Timeout (in seconds) for writing to client request" `
+	Path            string     `long:"http.path" default:"" description:"Path for the HTTP server context" `
+	Address         string     `long:"http.address" default:"0.0.0.0" description:"Address for the HTTP server listening" `
+	Port            int        `long:"http.port" default:"8080" description:"Port for the HTTP server listening" `
+	Trace           bool       `long:"http.trace" description:"Trace HTTP requests" `
+	IdleTimeout     int        `long:"http.idle" default:"0" description:"Timeout (in seconds) for idle connection" `
+	ReadTimeout     int        `long:"http.read" default:"0" description:"Timeout (in seconds) for reading client request" `
+	WriteTimeout    int        `long:"http.write" default:"0" description:"Timeout (in seconds) for writing to client request" `
+	ShutdownTimeout int        `long:"http.shutdown" default:"30" description:"Timeout (in seconds) for draining in-flight requests on shutdown" `
+	HandlerTimeout  int        `long:"http.handler_timeout" default:"0" description:"Timeout (in seconds) for handling a request, Handler.Timeout overrides it, 0 for none" `
+	H2C             bool       `long:"http.h2c" description:"Support HTTP/2 over cleartext TCP with prior knowledge" `
+	TrustedProxies  []string   `long:"http.trusted_proxy" description:"Proxy address or CIDR whose X-Forwarded-For is trusted, may be repeated" `
+	TLS             TLS        `group:"tls"`
+	Cors            Cors       `group:"cors"`
+	Security        Security   `group:"security"`
+	Csrf            Csrf       `group:"csrf"`
+	Session         Session    `group:"session"`
+	Pagination      Pagination `group:"pagination"`
+	Download        Download   `group:"download"`
+	Compress        Compress   `group:"compress"`
+	Access          Access     `group:"access"`
+}
+
+// Cors cross-origin settings, the origins are allowed exactly unless they contain a wildcard
+// such as https://*.example.com for the subdomains, or start with ~ for a regular expression
+type Cors struct {
+	Enabled     bool     `long:"http.cors" description:"Support CORS access, from any origin without credentials unless http.cors.origin is set" `
+	Origins     []string `long:"http.cors.origin" description:"Allowed origin, * allows any origin without credentials, may be repeated" `
+	Methods     []string `long:"http.cors.method" description:"Allowed method, may be repeated, defaults to GET, POST, PUT, PATCH, DELETE" `
+	Headers     []string `long:"http.cors.header" description:"Allowed request header besides the ones used by the server, may be repeated" `
+	Expose      []string `long:"http.cors.expose" description:"Response header exposed besides the ones set by the server, may be repeated" `
+	Credentials bool     `long:"http.cors.credentials" description:"Allows cookies and the Authorization header to be sent cross-origin" `
+	MaxAge      int      `long:"http.cors.max_age" default:"600" description:"Duration (in seconds) the browsers cache the preflight responses" `
+	Strict      bool     `long:"http.cors.strict" description:"Rejects with 403 the requests from origins not allowed instead of omitting the CORS headers" `
+}
+
+// UnmarshalJSON accepts the former boolean form of the settings, "Cors": true enables CORS.
+func (c *Cors) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Enabled); err == nil {
+		return nil
+	}
+	type cors Cors
+	return json.Unmarshal(data, (*cors)(c))
+}
+
+// UnmarshalYAML accepts the former boolean form of the settings, cors: true enables CORS.
+func (c *Cors) UnmarshalYAML(unmarshal func(any) error) error {
+	if err := unmarshal(&c.Enabled); err == nil {
+		return nil
+	}
+	type cors Cors
+	return unmarshal((*cors)(c))
+}
+
+// Security security headers settings, HSTS is sent on HTTPS requests only
+type Security struct {
+	Enabled           bool     `long:"http.security" description:"Sets the security headers on the responses" `
+	HSTS              int      `long:"http.security.hsts" default:"31536000" description:"Duration (in seconds) of Strict-Transport-Security, 0 disables it" `
+	HSTSSubdomains    bool     `long:"http.security.hsts_subdomains" description:"Applies Strict-Transport-Security to the subdomains" `
+	HSTSPreload       bool     `long:"http.security.hsts_preload" description:"Allows the domain in the HSTS preload lists" `
+	FrameOptions      string   `long:"http.security.frame_options" default:"DENY" description:"X-Frame-Options, DENY or SAMEORIGIN, empty disables it" `
+	ReferrerPolicy    string   `long:"http.security.referrer_policy" default:"strict-origin-when-cross-origin" description:"Referrer-Policy, empty disables it" `
+	PermissionsPolicy string   `long:"http.security.permissions_policy" default:"camera=(), microphone=(), geolocation=()" description:"Permissions-Policy, empty disables it" `
+	CSP               []string `long:"http.security.csp" description:"Content-Security-Policy directive such as \"script-src 'self'\", may be repeated, defaults to a same origin policy" `
+	CSPNonce          bool     `long:"http.security.csp_nonce" description:"Adds a nonce generated for each request to script-src and style-src" `
+	CSPReportOnly     bool     `long:"http.security.csp_report_only" description:"Reports the violations without enforcing the policy" `
+	CSPReport         bool     `long:"http.security.csp_report" description:"Collects the violation reports on /csp-report" `
+}
+
+// Csrf CSRF protection of the requests authenticated by cookies, the requests carrying
+// an Authorization header or the internal secret are exempt
+type Csrf struct {
+	Enabled        bool     `long:"http.csrf" description:"Requires a CSRF token on the unsafe requests carrying cookies" `
+	Secret         string   `long:"http.csrf.secret" description:"Key signing the tokens, random per instance when empty" `
+	Cookie         string   `long:"http.csrf.cookie" default:"csrf_token" description:"Name of the cookie holding the token" `
+	Header         string   `long:"http.csrf.header" default:"X-CSRF-Token" description:"Name of the header the token is sent back in" `
+	Field          string   `long:"http.csrf.field" default:"csrf_token" description:"Name of the form field the token is sent back in when the header is missing" `
+	TTL            int      `long:"http.csrf.ttl" default:"43200" description:"Lifetime (in seconds) of the tokens" `
+	TrustedOrigins []string `long:"http.csrf.trusted_origin" description:"Origin allowed besides the one of the server and the CORS origins, may be repeated" `
+}
+
+// Session cookie sessions, the login sets the token in a Secure, HttpOnly cookie
+type Session struct {
+	Enabled  bool   `long:"http.session" description:"Authenticates the requests without Authorization header by the session cookie" `
+	Cookie   string `long:"http.session.cookie" default:"session" description:"Name of the session cookie" `
+	Domain   string `long:"http.session.domain" description:"Domain of the session cookie, the host of the request when empty" `
+	Path     string `long:"http.session.path" default:"/" description:"Path of the session cookie" `
+	SameSite string `long:"http.session.same_site" default:"lax" description:"SameSite of the session cookie" choice:"strict" choice:"lax" choice:"none" `
+	Insecure bool   `long:"http.session.insecure" description:"Sends the session cookie over plain HTTP, for development only" `
+	TTL      int    `long:"http.session.ttl" default:"86400" description:"Lifetime (in seconds) of the session cookie, renewed with the token" `
+	Renew    int    `long:"http.session.renew" default:"900" description:"Age (in seconds) after which the token of an active session is rotated, 0 disables the rotation" `
+	Grace    int    `long:"http.session.grace" default:"30" description:"Duration (in seconds) a rotated token stays valid for the requests in flight" `
+}
+
+// Pagination page size settings of the paginated routes, and key of the cursors
+type Pagination struct {
+	Size    int    `long:"http.pagination.size" default:"20" description:"Page size when the request has none" `
+	MaxSize int    `long:"http.pagination.max_size" default:"100" description:"Largest page size a request may ask for" `
+	Secret  string `long:"http.pagination.secret" description:"Key signing the cursors, random per instance when empty" `
+}
+
+// Download signed download URLs settings
+type Download struct {
+	Secret string `long:"http.download.secret" description:"Key signing the download URLs, random per instance when empty" `
+	TTL    int    `long:"http.download.ttl" default:"3600" description:"Lifetime (in seconds) of the signed URLs when the caller gives none" `
+}
+
+// Access access log settings
+type Access struct {
+	File    string   `long:"http.access.file" description:"Name of a dedicated access log file rotated with the logs, the application log is used when empty" `
+	Success string   `long:"http.access.success" default:"info" description:"Level of the requests below 400, 4xx are logged at warn and 5xx at error" choice:"trace" choice:"debug" choice:"info" `
+	Sample  float64  `long:"http.access.sample" default:"1" description:"Ratio of the requests below 400 logged, between 0 and 1" `
+	Body    int      `long:"http.access.body" default:"0" description:"Maximum length (in bytes) of the request and response bodies logged, 0 disables the capture" `
+	Headers bool     `long:"http.access.headers" description:"Logs the request headers" `
+	Redact  []string `long:"http.access.redact" description:"Header name or JSON path such as $.card.number redacted, a bare field name is redacted at any depth, may be repeated" `
+	Skip    []string `long:"http.access.skip" description:"Path pattern (path.Match) not logged, may be repeated" `
+}
+
+// Compress response compression settings
+type Compress struct {
+	Enabled     bool     `long:"http.compress.enabled" description:"Compresses the responses with gzip or deflate according to Accept-Encoding" `
+	Level       int      `long:"http.compress.level" default:"-1" description:"Compression level from 1 to 9, -1 for the default" `
+	MinLength   int      `long:"http.compress.min_length" default:"1024" description:"Minimum length (in bytes) of the responses to compress" `
+	Exclude     []string `long:"http.compress.exclude" description:"Content type never compressed besides images, audio, video and archives, may be repeated" `
+	Decompress  bool     `long:"http.compress.decompress" description:"Decodes the request bodies sent with a gzip or deflate Content-Encoding" `
+	MaxInflated int64    `long:"http.compress.max_inflated" default:"10485760" description:"Maximum size (in bytes) of a decoded request body" `
+}
+
+// TLS HTTPS settings, HTTPS is enabled when both cert and key are set
+type TLS struct {
+	Cert         string   `long:"http.tls.cert" description:"Certificate file (PEM) for HTTPS" `
+	Key          string   `long:"http.tls.key" description:"Private key file (PEM) for HTTPS" `
+	MinVersion   string   `long:"http.tls.min_version" default:"1.2" description:"Minimum TLS version" choice:"1.0" choice:"1.1" choice:"1.2" choice:"1.3" `
+	CipherSuites []string `long:"http.tls.cipher_suite" description:"Cipher suite allowed below TLS 1.3, may be repeated" `
+	ClientCA     string   `long:"http.tls.client_ca" description:"CA file (PEM) to verify client certificates" `
+	ClientAuth   string   `long:"http.tls.client_auth" default:"none" description:"Client certificate policy" choice:"none" choice:"request" choice:"require" choice:"verify_if_given" choice:"require_and_verify" `
+	Reload       int      `long:"http.tls.reload" default:"60" description:"Interval (in seconds) for checking certificate changes, 0 reloads on SIGHUP only" `
+}
+
+func (m *TLS) Enabled() bool {
+	return m.Cert != "" && m.Key != ""
 }
 
 // Log logging settings
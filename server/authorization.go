@@ -1,7 +1,9 @@
 package server
 
 import (
+	"crypto/subtle"
 	"errors"
+	"net/http"
 	"slices"
 	"strings"
 
@@ -12,33 +14,111 @@ const ClientIDKey = "X-Client-ID"
 const AuthorizationKey = "Authorization"
 const InternalSecretKey = "X-Internal-Secret"
 
+// queryTokenContextKey marks the requests of the streams and the websockets, whose token may be sent in the query
+const queryTokenContextKey = "core.query_token"
+
 func (m *HttpServer) Authorization(ctx *Context) error {
 	if nil == m.authorization {
 		m.logger.Warn("Validation interface was called, but the validator component is nil")
 		return nil
 	}
-	endpoint := strings.TrimPrefix(ctx.Request.URL.Path, m.path)
+	// the routed path decides, a versioned route is the same whether the version is in the path or asked by header
+	endpoint, _ := trimPath(ctx.Request.URL.Path, m.path)
 	contained := slices.Contains(m.authorization.Settings().AnonEndpoints, endpoint)
-	m.logger.Debug("auth", "path", endpoint, "contained", contained)
+	m.logger.Debug("auth", "path", requestPath(ctx.Request), "endpoint", endpoint, "contained", contained)
 	if contained {
 		return nil
 	}
 	if m.authorization.Settings().DefaultPolicy == authorities.AuthorizationPolicyAllow {
 		return nil
 	}
-	token := ctx.GetHeader(AuthorizationKey)
+	return m.authenticate(ctx)
+}
+
+// accessToken returns the token of the Authorization header. EventSource and browser websockets can't set headers,
+// on the routes of Handler.Stream and the websockets their token is sent in the access_token query,
+// or as the protocol following access_token in Sec-WebSocket-Protocol.
+// The query isn't read on the other routes, where it would leak the token to the logs and the Referer.
+func accessToken(ctx *Context) string {
+	if token := ctx.GetHeader(AuthorizationKey); token != "" {
+		return token
+	}
+	if !ctx.GetBool(queryTokenContextKey) {
+		return ""
+	}
+	if token := ctx.Query(AccessTokenQuery); token != "" {
+		return token
+	}
+	if strings.EqualFold(ctx.GetHeader("Upgrade"), "websocket") {
+		protocols := websocketProtocols(ctx)
+		if i := slices.Index(protocols, AccessTokenQuery); i >= 0 && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+func (m *HttpServer) authenticate(ctx *Context) error {
+	if nil == m.authorization {
+		return errors.New("authorization component is nil")
+	}
+	token := accessToken(ctx)
+	var current *session
+	if "" == token && nil != m.sessions {
+		if current = m.sessions.read(ctx); nil != current {
+			token = m.sessionToken(current.token)
+		}
+	}
 	if "" == token {
 		return errors.New("authorization token required")
 	}
 
 	authorized, err := m.authorization.Authentication(ctx, token)
 	if nil != err {
+		if nil != current {
+			m.sessions.clear(ctx)
+		}
 		return errors.New("invalid token")
 	}
+	if nil != current {
+		m.renewSession(ctx, current, authorized)
+	}
 	ctx.Authorized = authorized
+	ctx.Set(authorizedContextKey, authorized)
 	return nil
 }
 
+// internalSecret reports whether the request carries the internal secret, never when none is configured.
+func (m *HttpServer) internalSecret(r *http.Request) bool {
+	if nil == m.authorization {
+		return false
+	}
+	secret := m.authorization.Settings().InternalSecret
+	return secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(InternalSecretKey)), []byte(secret)) == 1
+}
+
+// authorize applies the policy of the handler, the authorization settings are used when it has none.
+// A handler requiring a permission always needs an authenticated account.
+func (m *HttpServer) authorize(ctx *Context, handler *Handler) error {
+	switch handler.Policy {
+	case authorities.AuthorizationPolicyAllow:
+		return nil
+	case authorities.AuthorizationPolicyDeny:
+		return m.authenticate(ctx)
+	}
+	if handler.Permission != "" {
+		return m.authenticate(ctx)
+	}
+	return m.Authorization(ctx)
+}
+
+func (m *HttpServer) permitted(ctx *Context, handler *Handler) bool {
+	if handler.Permission == "" || handler.Policy == authorities.AuthorizationPolicyAllow {
+		return true
+	}
+	return nil != ctx.Authorized && ctx.Authorized.HasPermissions(handler.Permission)
+}
+
 // Permission unused
 func (m *HttpServer) Permission(endpoint string, authorized *authorities.Authorized) bool {
 	if slices.Contains(m.authorization.Settings().AnonEndpoints, endpoint) {
@@ -0,0 +1,517 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// CacheStatusKey tells whether the response was served from the cache, HIT or MISS
+	CacheStatusKey = "X-Cache"
+
+	cacheTagsContextKey = "core.cache_tags"
+	// cachingContextKey marks the responses being cached, their body carries no trace id
+	cachingContextKey = "core.caching"
+	defaultCacheTTL   = time.Minute
+)
+
+// Cache enables the caching of the responses of a GET route. The key is the path of the request
+// and the negotiated format, plus what is selected below. The responses with status 200 are cached
+// unless they are a Response with a code, such as the ones of WriteFail. They get a weak ETag and
+// the requests with a matching If-None-Match get 304. The concurrent requests of a key not cached
+// wait for the first one rather than all computing the response.
+type Cache struct {
+	// TTL is how long the responses are kept, a minute by default
+	TTL time.Duration
+	// Query adds the query string to the key
+	Query bool
+	// Account adds the authorized account to the key of the routes allowing anonymous requests,
+	// the key of the other routes has the account anyway and their responses are private
+	Account bool
+	// Tenant is the key of the principal holding the tenant of the account, added to the key when set
+	Tenant string
+	// Headers are the request headers added to the key, such as Accept-Language
+	Headers []string
+	// Tags are the tags of the responses for HttpServer.InvalidateCache, {name} is replaced
+	// by the route parameter, such as order:{id}. Context.CacheTags adds tags at runtime.
+	Tags []string
+	// MaxAge is the max-age sent to the clients, 0 sends no-cache so that they revalidate with the ETag
+	MaxAge time.Duration
+}
+
+func (c *Cache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return defaultCacheTTL
+}
+
+// cacheControl is public unless the response depends on the account or the route is authenticated.
+func (c *Cache) cacheControl(private bool) string {
+	scope := "public"
+	if private || c.Account || c.Tenant != "" {
+		scope = "private"
+	}
+	if c.MaxAge <= 0 {
+		return scope + ", no-cache"
+	}
+	return scope + ", max-age=" + strconv.Itoa(int(c.MaxAge.Seconds()))
+}
+
+// key returns the key of the request, the route and a hash of what the response varies with.
+// The account is part of it on the private routes, so that a response isn't served to another account.
+func (c *Cache) key(ctx *Context, private bool) string {
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Request.URL.Path + "\n" + ctx.NegotiateFormat(offers...) + "\n"))
+	if c.Query {
+		hash.Write([]byte(ctx.Request.URL.Query().Encode()))
+	}
+	hash.Write([]byte{0})
+	if (c.Account || private) && nil != ctx.Authorized {
+		hash.Write([]byte(ctx.Authorized.ID))
+	}
+	hash.Write([]byte{0})
+	if c.Tenant != "" && nil != ctx.Authorized {
+		fmt.Fprint(hash, ctx.Authorized.Principal.Get(c.Tenant))
+	}
+	for _, name := range c.Headers {
+		hash.Write([]byte{0})
+		hash.Write([]byte(ctx.GetHeader(name)))
+	}
+	return ctx.FullPath() + ":" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// vary lists the request headers the response varies with, Accept selects its format.
+func (c *Cache) vary() string {
+	return strings.Join(append([]string{"Accept"}, c.Headers...), ", ")
+}
+
+// cachedHeaders are the headers of the handler kept with the response, the other ones belong to the request.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "Content-Disposition", "Last-Modified", "Link"}
+
+// tags returns the tags of the route with the parameters replaced, and the ones added by the handler.
+func (c *Cache) tags(ctx *Context) []string {
+	tags := make([]string, 0, len(c.Tags))
+	for _, tag := range c.Tags {
+		for _, param := range ctx.Params {
+			tag = strings.ReplaceAll(tag, "{"+param.Key+"}", strings.TrimPrefix(param.Value, "/"))
+		}
+		tags = append(tags, tag)
+	}
+	if value, ok := ctx.Get(cacheTagsContextKey); ok {
+		tags = append(tags, value.([]string)...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// CacheTags adds tags to the response cached by the route, such as the ids of the items of a list.
+func (c *Context) CacheTags(tags ...string) {
+	var current []string
+	if value, ok := c.Get(cacheTagsContextKey); ok {
+		current = value.([]string)
+	}
+	c.Set(cacheTagsContextKey, append(current, tags...))
+}
+
+// CacheEntry is a cached response.
+type CacheEntry struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+	ETag   string      `json:"etag"`
+	Tags   []string    `json:"tags,omitempty"`
+	Stored time.Time   `json:"stored"`
+}
+
+// CacheStore keeps the cached responses.
+type CacheStore interface {
+	// Get returns the entry of the key, nil when there is none.
+	Get(ctx context.Context, key string) (*CacheEntry, error)
+	// Set stores the entry under the key and its tags.
+	Set(ctx context.Context, key string, entry *CacheEntry, ttl time.Duration) error
+	// Invalidate drops the entries of the tags.
+	Invalidate(ctx context.Context, tags ...string) error
+}
+
+type memoryCacheEntry struct {
+	entry   *CacheEntry
+	expires time.Time
+}
+
+type memoryCacheStore struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryCacheEntry
+	tags    map[string]map[string]struct{}
+	swept   time.Time
+}
+
+// NewMemoryCacheStore keeps the responses in the process, for single instance deployments.
+func NewMemoryCacheStore() CacheStore {
+	return &memoryCacheStore{
+		entries: make(map[string]*memoryCacheEntry),
+		tags:    make(map[string]map[string]struct{}),
+		swept:   time.Now(),
+	}
+}
+
+func (m *memoryCacheStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if stored, ok := m.entries[key]; ok && time.Now().Before(stored.expires) {
+		return stored.entry, nil
+	}
+	return nil, nil
+}
+
+func (m *memoryCacheStore) Set(ctx context.Context, key string, entry *CacheEntry, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	m.entries[key] = &memoryCacheEntry{entry: entry, expires: now.Add(ttl)}
+	for _, tag := range entry.Tags {
+		if nil == m.tags[tag] {
+			m.tags[tag] = make(map[string]struct{})
+		}
+		m.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+func (m *memoryCacheStore) Invalidate(ctx context.Context, tags ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, tag := range tags {
+		for key := range m.tags[tag] {
+			delete(m.entries, key)
+		}
+		delete(m.tags, tag)
+	}
+	return nil
+}
+
+// sweep drops the expired entries and their keys in the tags, once a minute.
+func (m *memoryCacheStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, stored := range m.entries {
+		if now.After(stored.expires) {
+			delete(m.entries, key)
+		}
+	}
+	for tag, keys := range m.tags {
+		for key := range keys {
+			if _, ok := m.entries[key]; !ok {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(m.tags, tag)
+		}
+	}
+}
+
+// cacheSetScript stores the entry and adds its key to the sets of its tags, which live as long as their longest entry.
+var cacheSetScript = redis.NewScript(`
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if redis.call('PTTL', KEYS[i]) < tonumber(ARGV[2]) then
+		redis.call('PEXPIRE', KEYS[i], ARGV[2])
+	end
+end
+return 1
+`)
+
+// cacheInvalidateScript drops the entries of the tags and the tags.
+var cacheInvalidateScript = redis.NewScript(`
+for i = 1, #KEYS do
+	for _, key in ipairs(redis.call('SMEMBERS', KEYS[i])) do
+		redis.call('DEL', key)
+	end
+	redis.call('DEL', KEYS[i])
+end
+return 1
+`)
+
+type redisCacheStore struct {
+	redis  redis.UniversalClient
+	prefix string
+}
+
+// NewRedisCacheStore shares the responses between instances through redis.
+func NewRedisCacheStore(client redis.UniversalClient, prefix string) CacheStore {
+	if prefix == "" {
+		prefix = "cache:"
+	}
+	return &redisCacheStore{redis: client, prefix: prefix}
+}
+
+func (r *redisCacheStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	value, err := r.redis.Get(ctx, r.prefix+"entry:"+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *redisCacheStore) Set(ctx context.Context, key string, entry *CacheEntry, ttl time.Duration) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	keys := []string{r.prefix + "entry:" + key}
+	for _, tag := range entry.Tags {
+		keys = append(keys, r.prefix+"tag:"+tag)
+	}
+	return cacheSetScript.Run(ctx, r.redis, keys, value, ttl.Milliseconds()).Err()
+}
+
+func (r *redisCacheStore) Invalidate(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, r.prefix+"tag:"+tag)
+	}
+	return cacheInvalidateScript.Run(ctx, r.redis, keys).Err()
+}
+
+// SetCacheStore sets the storage of the cached responses, the responses are kept in memory by default.
+func (m *HttpServer) SetCacheStore(store CacheStore) {
+	m.cache = store
+}
+
+// InvalidateCache drops the cached responses of the tags, such as after the update of an order.
+func (m *HttpServer) InvalidateCache(ctx context.Context, tags ...string) error {
+	return m.cache.Invalidate(ctx, tags...)
+}
+
+// cacheFlights coalesces the requests of the keys being computed.
+type cacheFlights struct {
+	mutex   sync.Mutex
+	flights map[string]*cacheFlight
+}
+
+type cacheFlight struct {
+	done  chan struct{}
+	entry *CacheEntry
+}
+
+// join returns the flight of the key, and whether the caller leads it and must call land.
+func (f *cacheFlights) join(key string) (*cacheFlight, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if nil == f.flights {
+		f.flights = make(map[string]*cacheFlight)
+	}
+	if flight, ok := f.flights[key]; ok {
+		return flight, false
+	}
+	flight := &cacheFlight{done: make(chan struct{})}
+	f.flights[key] = flight
+	return flight, true
+}
+
+func (f *cacheFlights) land(key string, flight *cacheFlight) {
+	f.mutex.Lock()
+	delete(f.flights, key)
+	f.mutex.Unlock()
+	close(flight.done)
+}
+
+// cached serves the response from the cache, or caches the response of serve.
+// The cache is bypassed when the store is unavailable.
+func (m *HttpServer) cached(ctx *Context, handler *Handler, serve func()) {
+	method := ctx.Request.Method
+	if nil == handler.Cache || (method != http.MethodGet && method != http.MethodHead) {
+		serve()
+		return
+	}
+	private := handler.Policy != authorities.AuthorizationPolicyAllow
+	key := handler.Cache.key(ctx, private)
+	entry, err := m.cache.Get(ctx.Request.Context(), key)
+	if err != nil {
+		m.logger.Warn("cache store unavailable, request handled", "key", key, "err", err)
+		serve()
+		return
+	}
+	if nil != entry {
+		writeCached(ctx, handler.Cache, private, entry, "HIT")
+		return
+	}
+
+	flight, leader := m.cacheFlights.join(key)
+	if !leader {
+		select {
+		case <-flight.done:
+		case <-ctx.Request.Context().Done():
+			return
+		}
+		if nil != flight.entry {
+			writeCached(ctx, handler.Cache, private, flight.entry, "HIT")
+			return
+		}
+		// the response of the leader wasn't cacheable
+		serve()
+		return
+	}
+	defer m.cacheFlights.land(key, flight)
+
+	buffer := &bufferedWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = buffer
+	ctx.Set(cachingContextKey, true)
+	func() {
+		defer func() {
+			ctx.Writer = buffer.ResponseWriter
+			ctx.Set(cachingContextKey, false)
+		}()
+		serve()
+	}()
+	status := buffer.Status()
+	if status != http.StatusOK || ctx.GetInt(responseCodeContextKey) != 0 {
+		// an error sent as a Response with status 200 isn't cached either
+		buffer.flush()
+		return
+	}
+
+	sum := sha256.Sum256(buffer.body)
+	entry = &CacheEntry{
+		Status: status,
+		Header: http.Header{},
+		Body:   buffer.body,
+		ETag:   `W/"` + hex.EncodeToString(sum[:16]) + `"`,
+		Tags:   handler.Cache.tags(ctx),
+		Stored: time.Now(),
+	}
+	for _, name := range cachedHeaders {
+		if values := ctx.Writer.Header().Values(name); len(values) > 0 {
+			entry.Header[name] = values
+		}
+	}
+	flight.entry = entry
+	if err := m.cache.Set(context.WithoutCancel(ctx.Request.Context()), key, entry, handler.Cache.ttl()); err != nil {
+		m.logger.Warn("cache store unavailable, response not cached", "key", key, "err", err)
+	}
+	writeCached(ctx, handler.Cache, private, entry, "MISS")
+}
+
+// writeCached writes the entry, or 304 when the client has it already.
+// The headers of the request, such as the CORS ones, are kept.
+func writeCached(ctx *Context, cache *Cache, private bool, entry *CacheEntry, status string) {
+	header := ctx.Writer.Header()
+	for name, values := range entry.Header {
+		header[name] = values
+	}
+	header.Set("ETag", entry.ETag)
+	header.Set("Cache-Control", cache.cacheControl(private))
+	header.Add("Vary", cache.vary())
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	header.Set(CacheStatusKey, status)
+	ctx.Abort()
+	if etagMatch(ctx.GetHeader("If-None-Match"), entry.ETag) {
+		ctx.Writer.WriteHeader(http.StatusNotModified)
+		ctx.Writer.WriteHeaderNow()
+		return
+	}
+	ctx.Writer.WriteHeader(entry.Status)
+	ctx.Writer.Write(entry.Body)
+}
+
+// etagMatch compares the If-None-Match list to the ETag weakly, as the GET requests do.
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds the response until it is known whether it is cached, the ETag is set
+// from the body before the headers are written.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.body = append(w.body, data...)
+	return len(data), nil
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bufferedWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if nil == w.body {
+		return -1
+	}
+	return len(w.body)
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.status != 0 || nil != w.body
+}
+
+// Flush is ignored, a streamed response is sent once complete.
+func (w *bufferedWriter) Flush() {}
+
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush writes the response held as is.
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.Status())
+	if nil == w.body {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.ResponseWriter.Write(w.body)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestCached(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger(), cache: NewMemoryCacheStore()}
+	handler := &Handler{Cache: &Cache{Account: true, Tags: []string{"order:{id}"}}}
+	var calls atomic.Int32
+	release := make(chan struct{})
+	close(release)
+	engine := gin.New()
+	engine.GET("/orders/:id", func(c *gin.Context) {
+		// set by the middleware for each request
+		c.Header("Access-Control-Allow-Origin", c.GetHeader("Origin"))
+		c.Set(requestIDContextKey, c.GetHeader("Trace"))
+		ctx := NewContext(c)
+		if account := c.GetHeader("Account"); account != "" {
+			ctx.Authorized = authorities.NewAuthorized(account, account, nil, nil)
+		}
+		srv.cached(ctx, handler, func() {
+			<-release
+			n := calls.Add(1)
+			if ctx.Param("id") == "missing" {
+				ctx.Negotiate(http.StatusNotFound, &Response{Code: http.StatusNotFound, Message: "not found"})
+				return
+			}
+			if ctx.Param("id") == "failing" {
+				ctx.WriteFail(http.StatusInternalServerError, "db down")
+				return
+			}
+			ctx.SetCookie("visited", "1", 0, "/", "", false, true)
+			ctx.WriteData(fmt.Sprintf("order %s %d", ctx.Param("id"), n))
+		})
+	})
+	send := func(target string, header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			request.Header.Set(name, values[0])
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := send("/orders/1", http.Header{"Account": {"alice"}, "Origin": {"https://a"}, "Trace": {"first"}})
+	second := send("/orders/1", http.Header{"Account": {"alice"}, "Origin": {"https://b"}, "Trace": {"second"}})
+	if first.Header().Get(CacheStatusKey) != "MISS" || second.Header().Get(CacheStatusKey) != "HIT" ||
+		first.Body.String() != second.Body.String() || calls.Load() != 1 {
+		t.Fatalf("expected the second response from the cache, got %s %s", first.Body.String(), second.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" || first.Header().Get("Cache-Control") != "private, no-cache" || second.Header().Get("Set-Cookie") != "" {
+		t.Fatalf("unexpected headers %v", first.Header())
+	}
+	if second.Header().Get("Access-Control-Allow-Origin") != "https://b" || second.Header().Get("Vary") != "Accept" ||
+		strings.Contains(second.Body.String(), "first") {
+		t.Fatalf("expected the headers and the trace id of the request, got %v %s", second.Header(), second.Body.String())
+	}
+	if recorder := send("/orders/1", http.Header{"Account": {"alice"}, "If-None-Match": {etag}}); recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+		t.Fatalf("expected not modified, got %d", recorder.Code)
+	}
+	if send("/orders/1", http.Header{"Account": {"bob"}}); calls.Load() != 2 {
+		t.Fatal("expected the accounts to have their own entries")
+	}
+
+	srv.InvalidateCache(context.Background(), "order:1")
+	if recorder := send("/orders/1", http.Header{"Account": {"alice"}}); recorder.Header().Get(CacheStatusKey) != "MISS" || calls.Load() != 3 {
+		t.Fatal("expected the entry to be invalidated")
+	}
+	send("/orders/missing", nil)
+	if recorder := send("/orders/missing", nil); recorder.Code != http.StatusNotFound || calls.Load() != 5 {
+		t.Fatalf("expected the errors not to be cached, got %d", recorder.Code)
+	}
+	send("/orders/failing", nil)
+	if recorder := send("/orders/failing", nil); recorder.Header().Get(CacheStatusKey) == "HIT" || calls.Load() != 7 {
+		t.Fatalf("expected the failures written with status 200 not to be cached, got %s", recorder.Body.String())
+	}
+
+	release = make(chan struct{})
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = send("/orders/2", nil).Body.String()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls.Load() != 8 {
+		t.Fatalf("expected the concurrent requests to be coalesced, got %d calls", calls.Load()-7)
+	}
+	for _, body := range bodies {
+		if body != bodies[0] {
+			t.Fatalf("unexpected bodies %v", bodies)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cache := &Cache{MaxAge: time.Minute}
+	if control := cache.cacheControl(false); control != "public, max-age=60" {
+		t.Fatalf("unexpected cache control %s", control)
+	}
+	if control := cache.cacheControl(true); control != "private, max-age=60" {
+		t.Fatalf("expected an authenticated route to be private, got %s", control)
+	}
+	if vary := (&Cache{Headers: []string{"Accept-Language"}}).vary(); vary != "Accept, Accept-Language" {
+		t.Fatalf("unexpected vary %s", vary)
+	}
+}
+
+func TestCachedAccounts(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, tokens)
+	profile := func(ctx *Context) error {
+		account := "anonymous"
+		if nil != ctx.Authorized {
+			account = ctx.Authorized.Account
+		}
+		ctx.WriteData(account)
+		return nil
+	}
+	srv.Get("/profile", &Handler{Cache: &Cache{}, Func: profile})
+	srv.Get("/catalog", &Handler{Policy: authorities.AuthorizationPolicyAllow, Cache: &Cache{}, Func: profile})
+	alice, _ := tokens.GenerateToken(authorities.NewAuthorized("1", "alice", nil, nil))
+	bob, _ := tokens.GenerateToken(authorities.NewAuthorized("2", "bob", nil, nil))
+
+	serve(srv, http.MethodGet, "/profile", AuthorizationKey, alice)
+	recorder := serve(srv, http.MethodGet, "/profile", AuthorizationKey, bob)
+	if !strings.Contains(recorder.Body.String(), `"bob"`) || recorder.Header().Get(CacheStatusKey) != "MISS" {
+		t.Fatalf("expected the response of another account not to be served, got %s %s", recorder.Header().Get(CacheStatusKey), recorder.Body.String())
+	}
+	if recorder := serve(srv, http.MethodGet, "/profile", AuthorizationKey, alice); !strings.Contains(recorder.Body.String(), `"alice"`) ||
+		recorder.Header().Get(CacheStatusKey) != "HIT" {
+		t.Fatalf("expected the response of the account to be cached, got %s %s", recorder.Header().Get(CacheStatusKey), recorder.Body.String())
+	}
+
+	// the responses of the public routes are shared
+	serve(srv, http.MethodGet, "/catalog")
+	if recorder := serve(srv, http.MethodGet, "/catalog"); recorder.Header().Get(CacheStatusKey) != "HIT" {
+		t.Fatalf("expected the public response to be shared, got %s", recorder.Header().Get(CacheStatusKey))
+	}
+}
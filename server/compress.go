@@ -0,0 +1,262 @@
+package server
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+
+	defaultMaxInflated = 10 << 20
+)
+
+// incompressible are the content types already compressed, matched by prefix
+var incompressible = []string{
+	"image/", "audio/", "video/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/pdf",
+	"application/octet-stream", "text/event-stream",
+}
+
+type compressor struct {
+	level     int
+	minLength int
+	exclude   []string
+	gzip      sync.Pool
+	deflate   sync.Pool
+}
+
+// Compress compresses the responses with gzip or deflate according to Accept-Encoding.
+// Responses shorter than the minimum length and the excluded content types are sent as is.
+func Compress(opts *option.Compress) gin.HandlerFunc {
+	m := &compressor{
+		level:     opts.Level,
+		minLength: opts.MinLength,
+		exclude:   append(append([]string(nil), incompressible...), opts.Exclude...),
+	}
+	if m.level < gzip.HuffmanOnly || m.level > gzip.BestCompression {
+		m.level = gzip.DefaultCompression
+	}
+	m.gzip.New = func() any {
+		writer, _ := gzip.NewWriterLevel(io.Discard, m.level)
+		return writer
+	}
+	m.deflate.New = func() any {
+		writer, _ := zlib.NewWriterLevel(io.Discard, m.level)
+		return writer
+	}
+
+	return func(c *gin.Context) {
+		encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		writer := &compressWriter{ResponseWriter: c.Writer, compressor: m, encoding: encoding}
+		c.Writer = writer
+		defer writer.finish()
+		c.Next()
+	}
+}
+
+// Decompress decodes the request bodies sent with a gzip or deflate Content-Encoding, the decoded
+// body is bounded by the max inflated size as the encoded length says nothing of it.
+func Decompress(opts *option.Compress) gin.HandlerFunc {
+	limit := opts.MaxInflated
+	if limit <= 0 {
+		limit = defaultMaxInflated
+	}
+	return func(c *gin.Context) {
+		var body io.ReadCloser
+		var err error
+		switch strings.ToLower(c.GetHeader("Content-Encoding")) {
+		case encodingGzip, "x-gzip":
+			body, err = gzip.NewReader(c.Request.Body)
+		case encodingDeflate:
+			body, err = zlib.NewReader(c.Request.Body)
+		default:
+			c.Next()
+			return
+		}
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		c.Request.Body = http.MaxBytesReader(c.Writer, body, limit)
+		c.Request.ContentLength = -1
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+		c.Next()
+	}
+}
+
+// acceptedEncoding returns the encoding of the highest quality, gzip first when equal.
+func acceptedEncoding(header string) string {
+	var encoding string
+	var best float64
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		if name == "*" {
+			name = encodingGzip
+		}
+		if (name != encodingGzip && name != encodingDeflate) || quality <= 0 {
+			continue
+		}
+		if quality > best || (quality == best && name == encodingGzip) {
+			encoding, best = name, quality
+		}
+	}
+	return encoding
+}
+
+// compressWriter buffers the response until the minimum length is reached,
+// then decides whether to compress it from the status and the content type.
+type compressWriter struct {
+	gin.ResponseWriter
+	compressor *compressor
+	encoding   string
+	buffer     []byte
+	decided    bool
+	encoder    io.WriteCloser
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buffer = append(w.buffer, data...)
+		if len(w.buffer) < w.compressor.minLength {
+			return len(data), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if nil != w.encoder {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteHeaderNow is delayed until the encoding is decided, the headers can't change once written.
+func (w *compressWriter) WriteHeaderNow() {
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Written() bool {
+	return len(w.buffer) > 0 || w.ResponseWriter.Written()
+}
+
+// Flush sends what is buffered, a flushed response is compressed when eligible whatever its length.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide sets the headers of the response and writes the buffer, compressed or not.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Type") == "" && len(w.buffer) > 0 {
+		// sniffed here as net/http would sniff the compressed bytes
+		header.Set("Content-Type", http.DetectContentType(w.buffer))
+	}
+	if compress && w.eligible(header) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		if w.encoding == encodingGzip {
+			encoder := w.compressor.gzip.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := w.compressor.deflate.Get().(*zlib.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+
+	buffer := w.buffer
+	w.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	if nil != w.encoder {
+		_, err := w.encoder.Write(buffer)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buffer)
+	return err
+}
+
+func (w *compressWriter) eligible(header http.Header) bool {
+	status := w.ResponseWriter.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent ||
+		status == http.StatusNotModified {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	header.Add("Vary", "Accept-Encoding")
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	for _, excluded := range w.compressor.exclude {
+		if strings.HasPrefix(contentType, excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+// finish writes the responses shorter than the minimum length as is, and closes the encoder.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if nil == w.encoder {
+		return
+	}
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		encoder.Reset(io.Discard)
+		w.compressor.gzip.Put(encoder)
+	case *zlib.Writer:
+		encoder.Reset(io.Discard)
+		w.compressor.deflate.Put(encoder)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompress(t *testing.T) {
+	engine := gin.New()
+	engine.Use(Decompress(&option.Compress{MaxInflated: 1024}), Compress(&option.Compress{Level: -1, MinLength: 64}))
+	engine.POST("/echo", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		c.Data(http.StatusOK, c.Query("type"), body)
+	})
+
+	serve := func(body string, contentType string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		encoder := gzip.NewWriter(&buf)
+		encoder.Write([]byte(body))
+		encoder.Close()
+		request := httptest.NewRequest(http.MethodPost, "/echo?type="+contentType, &buf)
+		request.Header.Set("Content-Encoding", "gzip")
+		request.Header.Set("Accept-Encoding", "deflate;q=0.5, gzip")
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	long := strings.Repeat("compressible ", 20)
+	recorder := serve(long, "text/plain")
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response, got %q", recorder.Header().Get("Content-Encoding"))
+	}
+	decoder, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(decoder); string(body) != long {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	if recorder = serve("short", "text/plain"); recorder.Header().Get("Content-Encoding") != "" || recorder.Body.String() != "short" {
+		t.Fatal("responses below the minimum length must not be compressed")
+	}
+	if recorder = serve(long, "image/png"); recorder.Header().Get("Content-Encoding") != "" || recorder.Body.String() != long {
+		t.Fatal("excluded content types must not be compressed")
+	}
+	if recorder = serve(strings.Repeat("a", 4096), "text/plain"); recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected the inflated body to be bounded, got %d", recorder.Code)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	engine := gin.New()
+	engine.GET("/data", func(c *gin.Context) {
+		NewContext(c).WriteData("content")
+	})
+	engine.GET("/map", func(c *gin.Context) {
+		NewContext(c).WriteData(map[string]any{"name": "content"})
+	})
+	for accept, expected := range map[string]string{
+		"":                      "application/json",
+		"application/xml":       "application/xml",
+		"application/x-msgpack": "application/msgpack",
+		"text/html, */*;q=0.1":  "application/json",
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/data", nil)
+		request.Header.Set("Accept", accept)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, expected) {
+			t.Fatalf("Accept %q: expected %s, got %s", accept, expected, contentType)
+		}
+	}
+
+	// encoding/xml can't encode the maps
+	request := httptest.NewRequest(http.MethodGet, "/map", nil)
+	request.Header.Set("Accept", "application/xml")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "application/json") || !strings.Contains(recorder.Body.String(), `"name":"content"`) {
+		t.Fatalf("expected a JSON fallback, got %s %q", recorder.Header().Get("Content-Type"), recorder.Body.String())
+	}
+}
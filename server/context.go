@@ -7,17 +7,22 @@
 package server
 
 import (
+	"encoding/xml"
 	"net/http"
 	"reflect"
-	"strconv"
 	"time"
 
 	"github.com/deepissue/core/authorities"
 	"github.com/deepissue/core/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
 	"github.com/go-playground/validator/v10"
 )
 
+// responseCodeContextKey holds the code of the Response written
+const responseCodeContextKey = "core.response_code"
+
 var validate *validator.Validate
 
 func init() {
@@ -37,6 +42,9 @@ type Context struct {
 	RemoteAddr string
 	ClientID   string
 	Header     http.Header
+	// writeTimeout bounds each write of a stream, see Stream
+	writeTimeout time.Duration
+	stream       *Stream
 }
 
 func NewContext(c *gin.Context) *Context {
@@ -54,23 +62,15 @@ func (c *Context) ValidateStruct(out any) error {
 	return validate.Struct(out)
 }
 
+// PageNumber returns the page of the request, 1 when it is missing or not positive.
 func (c *Context) PageNumber() int {
-
-	page := c.Context.DefaultQuery("page", "1")
-	ret, err := strconv.Atoi(page)
-	if err != nil {
-		return 1
-	}
-	return ret
+	return c.PageRequest().Page
 }
 
+// PageSize returns the size of the page of the request, the default size when it is missing
+// and at most the max size, see option.Pagination.
 func (c *Context) PageSize() int {
-	page := c.Context.DefaultQuery("size", "20")
-	ret, err := strconv.Atoi(page)
-	if err != nil {
-		return 20
-	}
-	return ret
+	return c.PageRequest().Size
 }
 
 func (c *Context) ShouldBindJSON(out any) error {
@@ -81,8 +81,64 @@ func (c *Context) ShouldBindJSON(out any) error {
 	return validate.Struct(out)
 }
 
+// ShouldBind binds the body according to its Content-Type, such as JSON, XML or MessagePack, then validates it.
+func (c *Context) ShouldBind(out any) error {
+	err := c.Context.ShouldBind(out)
+	if err != nil {
+		return err
+	}
+	return validate.Struct(out)
+}
+
+// offers are the formats of the responses negotiated with Accept, JSON is the default.
+var offers = []string{binding.MIMEJSON, binding.MIMEXML, binding.MIMEXML2, binding.MIMEMSGPACK, binding.MIMEMSGPACK2}
+
+// Negotiate writes the data in the format accepted by the client, JSON when it accepts none of them.
+// A Response without trace id gets the id of the request, unless it is cached.
+func (c *Context) Negotiate(code int, data any) {
+	c.Abort()
+	if response, ok := data.(*Response); ok {
+		c.Set(responseCodeContextKey, response.Code)
+		if response.TraceID == "" && !c.GetBool(cachingContextKey) {
+			response.TraceID = c.RequestID()
+		}
+	}
+	c.Render(code, negotiatedRender(c.NegotiateFormat(offers...), data))
+}
+
+func negotiatedRender(format string, data any) render.Render {
+	switch format {
+	case binding.MIMEXML, binding.MIMEXML2:
+		return xmlRender{Data: data}
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		return render.MsgPack{Data: data}
+	default:
+		return render.JSON{Data: data}
+	}
+}
+
+// xmlRender encodes the data before writing it, the data encoding/xml can't encode, such as the maps,
+// is sent as JSON rather than as an empty body.
+type xmlRender struct {
+	Data any
+}
+
+func (r xmlRender) Render(w http.ResponseWriter) error {
+	encoded, err := xml.Marshal(r.Data)
+	if err != nil {
+		return render.JSON{Data: r.Data}.Render(w)
+	}
+	r.WriteContentType(w)
+	_, err = w.Write(encoded)
+	return err
+}
+
+func (r xmlRender) WriteContentType(w http.ResponseWriter) {
+	render.XML{}.WriteContentType(w)
+}
+
 func (c *Context) WriteFail(code int, message string) {
-	c.AbortWithStatusJSON(200, &Response{
+	c.Negotiate(200, &Response{
 		Code:      code,
 		Message:   message,
 		Timestamp: time.Now().Local().Unix(),
@@ -91,11 +147,11 @@ func (c *Context) WriteFail(code int, message string) {
 
 func (c *Context) WriteResponse(res *Response) {
 	res.Timestamp = time.Now().Local().Unix()
-	c.AbortWithStatusJSON(200, res)
+	c.Negotiate(200, res)
 }
 
 func (c *Context) Write(code int, data any) {
-	c.AbortWithStatusJSON(200, data)
+	c.Negotiate(200, data)
 }
 
 func (c *Context) WriteData(data any) {
@@ -106,8 +162,11 @@ func (c *Context) WriteData(data any) {
 	})
 }
 
+// WriteDataWithPagination writes the data of a page, a *utils.Pagination or a *utils.CursorPagination
+// also sets the Link header.
 func (c *Context) WriteDataWithPagination(data any, pagination any) {
-	c.AbortWithStatusJSON(200, &Response{
+	c.setLinks(pagination)
+	c.Negotiate(200, &Response{
 		Code:       0,
 		Content:    data,
 		Pagination: pagination,
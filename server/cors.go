@@ -0,0 +1,335 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	defaultCorsMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	// defaultCorsHeaders are the request headers read by the server
+	defaultCorsHeaders = []string{
+		"Accept", "Accept-Language", "Content-Language", "Content-Type",
+		AuthorizationKey, ClientIDKey, RequestIDKey, IdempotencyKey, LastEventIDKey, CSRFTokenKey,
+		"Os-Version", "Application-Version",
+	}
+	// defaultCorsExpose are the response headers set by the server
+	defaultCorsExpose = []string{
+		RequestIDKey, IdempotentReplayedKey, "Location", "Content-Disposition",
+		"API-Version", "Deprecation", "Sunset",
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+	}
+)
+
+// CorsPolicy allows cross-origin requests from the Origins. An origin is allowed exactly unless
+// it contains a wildcard such as https://*.example.com for the subdomains, or starts with ~ for
+// a regular expression matching the whole origin. * allows any origin, but not with Credentials.
+// The methods and headers default to the ones used by the server.
+type CorsPolicy struct {
+	Origins     []string
+	Methods     []string
+	Headers     []string
+	Expose      []string
+	Credentials bool
+	MaxAge      time.Duration
+	// Strict rejects the requests from the origins not allowed with 403,
+	// otherwise they are served without the CORS headers and the browser blocks them.
+	Strict bool
+
+	anyOrigin bool
+	exact     map[string]bool
+	wildcards []wildcardOrigin
+	patterns  []*regexp.Regexp
+	methods   string
+	headers   string
+	expose    string
+}
+
+type wildcardOrigin struct {
+	scheme string
+	suffix string
+}
+
+// NewCorsPolicy returns the policy of the settings. Any origin is allowed when they list none,
+// as http.cors alone did, the credentials then need the origins to be listed.
+func NewCorsPolicy(opts *option.Cors) (*CorsPolicy, error) {
+	origins := opts.Origins
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	policy := &CorsPolicy{
+		Origins:     origins,
+		Methods:     opts.Methods,
+		Headers:     opts.Headers,
+		Expose:      opts.Expose,
+		Credentials: opts.Credentials,
+		MaxAge:      time.Duration(opts.MaxAge) * time.Second,
+		Strict:      opts.Strict,
+	}
+	if err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (p *CorsPolicy) compile() error {
+	if len(p.Origins) == 0 {
+		return errors.New("cors: no origin allowed")
+	}
+	p.exact = make(map[string]bool)
+	p.anyOrigin, p.wildcards, p.patterns = false, nil, nil
+	for _, origin := range p.Origins {
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(origin, "~"):
+			pattern, err := regexp.Compile("^(?:" + origin[1:] + ")$")
+			if err != nil {
+				return fmt.Errorf("cors: origin %s: %w", origin, err)
+			}
+			p.patterns = append(p.patterns, pattern)
+		case strings.Contains(origin, "*"):
+			wildcard, err := parseWildcardOrigin(origin)
+			if err != nil {
+				return err
+			}
+			p.wildcards = append(p.wildcards, wildcard)
+		default:
+			p.exact[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+	if p.anyOrigin && p.Credentials {
+		return errors.New("cors: credentials can't be allowed for any origin")
+	}
+
+	methods := p.Methods
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+	p.methods = strings.ToUpper(strings.Join(methods, ", "))
+	p.headers = strings.Join(mergeHeaders(defaultCorsHeaders, p.Headers), ", ")
+	p.expose = strings.Join(mergeHeaders(defaultCorsExpose, p.Expose), ", ")
+	return nil
+}
+
+// parseWildcardOrigin parses the origins such as https://*.example.com, *.example.com allows any scheme.
+func parseWildcardOrigin(origin string) (wildcardOrigin, error) {
+	scheme, host, found := strings.Cut(strings.ToLower(strings.TrimSuffix(origin, "/")), "://")
+	if !found {
+		scheme, host = "", scheme
+	}
+	if !strings.HasPrefix(host, "*.") || strings.Contains(host[1:], "*") || strings.Contains(scheme, "*") {
+		return wildcardOrigin{}, fmt.Errorf("cors: origin %s: only a leading *. wildcard is supported", origin)
+	}
+	return wildcardOrigin{scheme: scheme, suffix: host[1:]}, nil
+}
+
+func mergeHeaders(defaults []string, headers []string) []string {
+	merged := slices.Clone(defaults)
+	for _, header := range headers {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != "" && !slices.Contains(merged, header) {
+			merged = append(merged, header)
+		}
+	}
+	return merged
+}
+
+// Allowed reports whether the requests from the origin are allowed.
+func (p *CorsPolicy) Allowed(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	if scheme, host, found := strings.Cut(origin, "://"); found {
+		for _, wildcard := range p.wildcards {
+			if (wildcard.scheme == "" || wildcard.scheme == scheme) &&
+				len(host) > len(wildcard.suffix) && strings.HasSuffix(host, wildcard.suffix) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware returns a middleware applying the policy to every route, it panics when the policy is invalid.
+func (p *CorsPolicy) Middleware() gin.HandlerFunc {
+	if err := p.compile(); err != nil {
+		panic(err)
+	}
+	return func(c *gin.Context) {
+		p.serve(c)
+	}
+}
+
+// Cors returns a middleware applying the CORS settings of the options to every route,
+// see NewCorsPolicy. It panics when they are invalid.
+//
+// Deprecated: the HttpServer applies the settings itself when http.cors is set, use NewCorsPolicy
+// and CorsPolicy.Middleware for another engine. Any origin is no longer allowed with credentials.
+func Cors(opts *option.Options) gin.HandlerFunc {
+	policy, err := NewCorsPolicy(&opts.Http.Cors)
+	if err != nil {
+		panic(err)
+	}
+	return policy.Middleware()
+}
+
+// cors applies the policy of the route, or the default policy, which is nil when CORS is disabled.
+// The preflight requests are answered here, they reach it through the handlers of gin for the routes
+// not found when the path has no OPTIONS route. Their policy is the one of the route of the requested
+// method matching the path, the route of gin being the OPTIONS one if any.
+func (m *HttpServer) cors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var policy *CorsPolicy
+		var ok bool
+		if preflight(c.Request) {
+			policy, ok = m.corsTemplate(c.GetHeader("Access-Control-Request-Method"), c.Request.URL.Path)
+		} else {
+			policy, ok = m.corsRoutes[c.Request.Method+" "+c.FullPath()]
+		}
+		if !ok {
+			policy = m.corsPolicy
+		}
+		if nil == policy {
+			c.Next()
+			return
+		}
+		policy.serve(c)
+	}
+}
+
+func (p *CorsPolicy) serve(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		c.Next()
+		return
+	}
+	header := c.Writer.Header()
+	header.Add("Vary", "Origin")
+	isPreflight := preflight(c.Request)
+	if !isPreflight && sameOrigin(c.Request, origin) {
+		c.Next()
+		return
+	}
+
+	if !p.Allowed(origin) {
+		switch {
+		case p.Strict:
+			NewContext(c).Negotiate(http.StatusForbidden, &Response{
+				Code:      http.StatusForbidden,
+				Message:   "origin not allowed",
+				Timestamp: time.Now().Local().Unix(),
+			})
+		case isPreflight:
+			c.AbortWithStatus(http.StatusNoContent)
+		default:
+			c.Next()
+		}
+		return
+	}
+
+	if p.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if isPreflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", p.methods)
+		header.Set("Access-Control-Allow-Headers", p.headers)
+		if p.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+	header.Set("Access-Control-Expose-Headers", p.expose)
+	c.Next()
+}
+
+// routePolicy is the policy of a route, matched by its path for the preflight requests
+type routePolicy struct {
+	method   string
+	template []string
+	policy   *CorsPolicy
+}
+
+// corsRoute overrides the policy of the route, it fails when the policy is invalid.
+func (m *HttpServer) corsRoute(method string, path string, policy *CorsPolicy) error {
+	if err := policy.compile(); err != nil {
+		return err
+	}
+	// the key of the route is the full path seen by the middleware
+	path = "/" + strings.TrimPrefix(path, "/")
+	m.corsRoutes[method+" "+path] = policy
+	m.corsTemplates = append(m.corsTemplates, &routePolicy{
+		method:   method,
+		template: strings.Split(strings.Trim(path, "/"), "/"),
+		policy:   policy,
+	})
+	return nil
+}
+
+// corsTemplate returns the policy of the route matching the path, the static segments before the parameters
+// as gin does. The routes registered first win between parameters.
+func (m *HttpServer) corsTemplate(method string, path string) (*CorsPolicy, bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var matched *routePolicy
+	for _, route := range m.corsTemplates {
+		if route.method != method || !matchTemplate(route.template, segments) {
+			continue
+		}
+		if nil == matched || staticSegments(route.template) > staticSegments(matched.template) {
+			matched = route
+		}
+	}
+	if nil == matched {
+		return nil, false
+	}
+	return matched.policy, true
+}
+
+func staticSegments(template []string) int {
+	n := 0
+	for _, segment := range template {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			n++
+		}
+	}
+	return n
+}
+
+func preflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// sameOrigin reports whether the origin is the one of the server, browsers send it with the unsafe methods.
+func sameOrigin(r *http.Request, origin string) bool {
+	scheme := "http"
+	if https(r) {
+		scheme = "https"
+	}
+	return strings.EqualFold(origin, scheme+"://"+r.Host)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+)
+
+func TestCorsPolicy(t *testing.T) {
+	policy, err := NewCorsPolicy(&option.Cors{
+		Origins:     []string{"https://app.example.com", "https://*.example.org", `~https://[a-z]+\.example\.net`},
+		Credentials: true,
+		MaxAge:      600,
+		Strict:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":        true,
+		"https://APP.example.com":        true,
+		"https://evil.com":               false,
+		"https://a.b.example.org":        true,
+		"https://example.org":            false,
+		"http://a.example.org":           false,
+		"https://ab.example.net":         true,
+		"https://ab.example.net.evil.io": false,
+	} {
+		if policy.Allowed(origin) != allowed {
+			t.Fatalf("expected %s allowed %v", origin, allowed)
+		}
+	}
+
+	if _, err := NewCorsPolicy(&option.Cors{Origins: []string{"*"}, Credentials: true}); err == nil {
+		t.Fatal("expected credentials to be refused for any origin")
+	}
+	if _, err := NewCorsPolicy(&option.Cors{Origins: []string{"https://app.*.com"}}); err == nil {
+		t.Fatal("expected the wildcard to be refused")
+	}
+
+	engine := gin.New()
+	engine.Use(policy.Middleware())
+	engine.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+	engine.NoRoute(func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	request := func(method string, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/orders", nil)
+		r.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	recorder := request(http.MethodOptions, "https://app.example.com")
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		recorder.Header().Get("Access-Control-Allow-Credentials") != "true" || recorder.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("unexpected preflight response %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder := request(http.MethodGet, "https://evil.com"); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected the origin to be rejected, got %d", recorder.Code)
+	}
+	if recorder := request(http.MethodGet, "http://example.com"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the same origin to be served, got %d", recorder.Code)
+	}
+}
+
+func TestCorsRoutes(t *testing.T) {
+	srv := newTestServer(t, &authorities.Settings{}, nil, "--http.cors", "--http.cors.origin", "https://app.example.com")
+	handler := func(ctx *Context) error {
+		ctx.WriteData(ctx.FullPath())
+		return nil
+	}
+	srv.Get("/orders/:id", &Handler{Cors: &CorsPolicy{Origins: []string{"https://partner.com"}}, Func: handler})
+	srv.Get("/orders/export", &Handler{Cors: &CorsPolicy{Origins: []string{"https://export.com"}}, Func: handler})
+	// the preflight routes are no longer registered, so neither the names of the params nor an OPTIONS route conflict
+	srv.Delete("/orders/:order", &Handler{Cors: &CorsPolicy{Origins: []string{"https://admin.com"}, Methods: []string{http.MethodDelete}}, Func: handler})
+	srv.Options("/orders/:id", &Handler{Func: handler})
+	srv.Get("/status", &Handler{Func: handler})
+	srv.Get("/invalid", &Handler{Cors: &CorsPolicy{}, Func: handler})
+
+	preflight := func(method string, target string, origin string) string {
+		recorder := serve(srv, http.MethodOptions, target, "Origin", origin, "Access-Control-Request-Method", method)
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("expected the preflight of %s %s answered, got %d", method, target, recorder.Code)
+		}
+		return recorder.Header().Get("Access-Control-Allow-Origin")
+	}
+	for _, test := range []struct {
+		method, target, origin string
+		allowed                bool
+	}{
+		{http.MethodGet, "/orders/1", "https://partner.com", true},
+		{http.MethodGet, "/orders/1", "https://app.example.com", false},
+		{http.MethodGet, "/orders/export", "https://export.com", true},
+		{http.MethodGet, "/orders/export", "https://partner.com", false},
+		{http.MethodDelete, "/orders/1", "https://admin.com", true},
+		{http.MethodGet, "/status", "https://app.example.com", true},
+	} {
+		if allowed := preflight(test.method, test.target, test.origin) == test.origin; allowed != test.allowed {
+			t.Fatalf("expected the preflight of %s %s from %s allowed %v", test.method, test.target, test.origin, test.allowed)
+		}
+	}
+	if recorder := serve(srv, http.MethodOptions, "/orders/1"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the OPTIONS route to be served, got %d", recorder.Code)
+	}
+
+	if recorder := serve(srv, http.MethodGet, "/invalid"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected the route with an invalid policy not registered, got %d", recorder.Code)
+	}
+	if err := srv.Startup(); err == nil || !strings.Contains(err.Error(), "invalid: cors: no origin allowed") {
+		t.Fatalf("expected the startup to fail with the invalid route, got %v", err)
+	}
+}
+
+func TestCorsSettings(t *testing.T) {
+	// http.cors alone allows any origin without credentials, as the former boolean setting did
+	srv := newTestServer(t, &authorities.Settings{}, nil, "--http.cors")
+	srv.Get("/orders", &Handler{Func: func(ctx *Context) error {
+		ctx.WriteData("orders")
+		return nil
+	}})
+	recorder := serve(srv, http.MethodGet, "/orders", "Origin", "https://any.example.com")
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "*" || recorder.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("expected any origin allowed without credentials, got %v", recorder.Header())
+	}
+
+	var opts option.Options
+	if err := json.Unmarshal([]byte(`{"Http": {"Cors": true}}`), &opts); err != nil || !opts.Http.Cors.Enabled {
+		t.Fatalf("expected the boolean form to enable CORS, got %+v %v", opts.Http.Cors, err)
+	}
+	opts = option.Options{}
+	if err := json.Unmarshal([]byte(`{"Http": {"Cors": {"Enabled": true, "Origins": ["https://app.example.com"]}}}`), &opts); err != nil ||
+		!opts.Http.Cors.Enabled || len(opts.Http.Cors.Origins) != 1 {
+		t.Fatalf("expected the settings, got %+v %v", opts.Http.Cors, err)
+	}
+
+	engine := gin.New()
+	engine.Use(Cors(&option.Options{Http: option.Http{Cors: option.Cors{Enabled: true}}}))
+	engine.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	request.Header.Set("Origin", "https://any.example.com")
+	engine.ServeHTTP(recorder, request)
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected the former middleware to allow any origin, got %v", recorder.Header())
+	}
+}
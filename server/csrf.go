@@ -0,0 +1,257 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+	"github.com/hashicorp/go-hclog"
+)
+
+const CSRFTokenKey = "X-CSRF-Token"
+
+const (
+	csrfContextKey      = "core.csrf"
+	csrfTokenContextKey = "core.csrf_token"
+	// sessionContextKey holds the token of the session cookie written by the request, at the login or its rotation
+	sessionContextKey = "core.session"
+)
+
+var (
+	errCSRFOrigin = errors.New("cross-site request refused")
+	errCSRFToken  = errors.New("invalid csrf token")
+)
+
+// csrfProtection checks the unsafe requests authenticated by cookies. The token is signed
+// with the session it was issued to and sent both in a cookie and in a header or a form
+// field, a cross-site request can't read the cookie to send it back.
+type csrfProtection struct {
+	key      *signingKey
+	sessions *sessionCookies
+	cookie   string
+	header   string
+	field    string
+	ttl      time.Duration
+	trusted  []string
+}
+
+// newCSRFProtection binds the tokens to the session cookies, to none when the sessions are disabled.
+func newCSRFProtection(opts *option.Csrf, sessions *sessionCookies, logger hclog.Logger) *csrfProtection {
+	key := newSigningKey(opts.Secret, "http.csrf.secret")
+	key.warn(logger)
+	protection := &csrfProtection{
+		key:      key,
+		sessions: sessions,
+		cookie:   opts.Cookie,
+		header:   opts.Header,
+		field:    opts.Field,
+		ttl:      time.Duration(opts.TTL) * time.Second,
+		trusted:  opts.TrustedOrigins,
+	}
+	if protection.cookie == "" {
+		protection.cookie = "csrf_token"
+	}
+	if protection.header == "" {
+		protection.header = CSRFTokenKey
+	}
+	if protection.ttl <= 0 {
+		protection.ttl = 12 * time.Hour
+	}
+	return protection
+}
+
+// issue returns a token of the session, the payload is a random nonce and the expiry.
+func (p *csrfProtection) issue(session string) string {
+	payload := make([]byte, 24)
+	rand.Read(payload[:16])
+	binary.BigEndian.PutUint64(payload[16:], uint64(time.Now().Add(p.ttl).Unix()))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(p.sign(session, payload))
+}
+
+func (p *csrfProtection) sign(session string, payload []byte) []byte {
+	mac := p.key.mac()
+	mac.Write([]byte(session))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// valid reports whether the token was issued to the session and is not expired.
+func (p *csrfProtection) valid(token string, session string) bool {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) != 24 {
+		return false
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sum, p.sign(session, payload)) {
+		return false
+	}
+	return time.Now().Unix() < int64(binary.BigEndian.Uint64(payload[16:]))
+}
+
+// setCookie sends the token in a cookie readable by the scripts of the application.
+func (p *csrfProtection) setCookie(ctx *Context, token string) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     p.cookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(p.ttl.Seconds()),
+		Secure:   https(ctx.Request),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// csrfExempt reports whether the request carries no ambient credentials: browsers add
+// the cookies to cross-site requests, but never the Authorization header or the internal secret.
+// Only the valid internal secret exempts the request, any other value would skip the check.
+func (m *HttpServer) csrfExempt(ctx *Context) bool {
+	return ctx.GetHeader(AuthorizationKey) != "" || m.internalSecret(ctx.Request) ||
+		len(ctx.Request.Cookies()) == 0
+}
+
+// requestSession returns the token of the session cookie sent with the request, none before the login.
+func (p *csrfProtection) requestSession(ctx *Context) string {
+	if nil == p.sessions {
+		return ""
+	}
+	if current := p.sessions.read(ctx); nil != current {
+		return current.token
+	}
+	return ""
+}
+
+// session returns what the tokens are tied to, the session written by the request once it logged in
+// or rotated its token, else the one it was sent with.
+func (p *csrfProtection) session(ctx *Context) string {
+	if token := ctx.GetString(sessionContextKey); token != "" {
+		return token
+	}
+	return p.requestSession(ctx)
+}
+
+// csrfProtected checks the origin and the token of the unsafe requests, and issues a token
+// on the safe ones when the cookie is missing. It reports whether the request was refused.
+func (m *HttpServer) csrfProtected(ctx *Context, handler *Handler) bool {
+	if nil == m.csrf {
+		return false
+	}
+	ctx.Set(csrfContextKey, m.csrf)
+	if handler.CSRFExempt || m.csrfExempt(ctx) {
+		return false
+	}
+	// the session rotated by the authentication gets a token of its own
+	rotated := ctx.GetString(sessionContextKey) != ""
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		// a token of another session is replaced by CSRFToken only, the route may not be authenticated
+		if _, err := ctx.Cookie(m.csrf.cookie); err != nil || rotated {
+			ctx.CSRFToken()
+		}
+		return false
+	}
+
+	err := errCSRFOrigin
+	if m.trustedOrigin(ctx) {
+		err = errCSRFToken
+		cookie, _ := ctx.Cookie(m.csrf.cookie)
+		token := ctx.GetHeader(m.csrf.header)
+		if token == "" && m.csrf.field != "" {
+			token = ctx.PostForm(m.csrf.field)
+		}
+		if cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(token)) == 1 &&
+			m.csrf.valid(token, m.csrf.requestSession(ctx)) {
+			if rotated {
+				ctx.CSRFToken()
+			}
+			return false
+		}
+	}
+	m.logger.Debug("csrf check failed", "path", requestPath(ctx.Request), "origin", ctx.GetHeader("Origin"), "err", err)
+	ctx.Negotiate(http.StatusForbidden, &Response{
+		Code:      http.StatusForbidden,
+		Message:   err.Error(),
+		Timestamp: time.Now().Local().Unix(),
+	})
+	return true
+}
+
+// trustedOrigin checks the Origin header, or the Referer when the browser sent no Origin.
+// Requests with neither are left to the token check.
+func (m *HttpServer) trustedOrigin(ctx *Context) bool {
+	origin := ctx.GetHeader("Origin")
+	if origin == "" {
+		referer, err := url.Parse(ctx.GetHeader("Referer"))
+		if err != nil || referer.Host == "" {
+			return true
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+	if origin == "null" {
+		return false
+	}
+	if sameOrigin(ctx.Request, origin) {
+		return true
+	}
+	if nil != m.csrf && slices.ContainsFunc(m.csrf.trusted, func(trusted string) bool {
+		return strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin)
+	}) {
+		return true
+	}
+	// any origin may be allowed by CORS without credentials, that is no trust
+	return nil != m.corsPolicy && !m.corsPolicy.anyOrigin && m.corsPolicy.Allowed(origin)
+}
+
+// CSRFToken returns the CSRF token of the session, to be sent back by the scripts in the
+// X-CSRF-Token header or rendered in the csrf_token field of the forms. The token is set in
+// the cookie when it changes. It is empty when the CSRF protection is disabled.
+func (c *Context) CSRFToken() string {
+	value, ok := c.Get(csrfContextKey)
+	if !ok {
+		return ""
+	}
+	protection := value.(*csrfProtection)
+	session := protection.session(c)
+	if token := c.GetString(csrfTokenContextKey); token != "" && protection.valid(token, session) {
+		return token
+	}
+	if token, err := c.Cookie(protection.cookie); err == nil && protection.valid(token, session) {
+		return token
+	}
+	token := protection.issue(session)
+	protection.setCookie(c, token)
+	c.Set(csrfTokenContextKey, token)
+	return token
+}
+
+type csrfTokenReply struct {
+	Token string `json:"token"`
+}
+
+// csrfToken serves the token of the session to the scripts which can't read the cookie,
+// the token is tied to the session cookie sent with the request as the unsafe requests check it.
+func (m *HttpServer) csrfToken() {
+	m.Get("csrf-token", &Handler{
+		Name:   "csrf token",
+		Tags:   []string{"security"},
+		Policy: authorities.AuthorizationPolicyAllow,
+		Reply:  &csrfTokenReply{},
+		Func: func(ctx *Context) error {
+			ctx.WriteData(&csrfTokenReply{Token: ctx.CSRFToken()})
+			return nil
+		},
+	})
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestCSRF(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger()}
+	srv.csrf = newCSRFProtection(&option.Csrf{Secret: "secret", Field: "csrf_token"}, nil, srv.logger)
+	engine := gin.New()
+	handle := func(c *gin.Context) {
+		ctx := NewContext(c)
+		if !srv.csrfProtected(ctx, &Handler{}) {
+			ctx.WriteData("ok")
+		}
+	}
+	engine.GET("/orders", handle)
+	engine.POST("/orders", handle)
+
+	send := func(method string, header http.Header, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, "http://example.com/orders", strings.NewReader(body))
+		for name, values := range header {
+			request.Header.Set(name, values[0])
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := send(http.MethodGet, http.Header{"Cookie": {"session=1"}}, "")
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "csrf_token" {
+		t.Fatalf("expected the token to be issued, got %v", cookies)
+	}
+	token := cookies[0].Value
+	cookie := "session=1; csrf_token=" + token
+
+	if recorder := send(http.MethodPost, http.Header{"Cookie": {cookie}, CSRFTokenKey: {token}, "Origin": {"http://example.com"}}, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the token to be accepted, got %d", recorder.Code)
+	}
+	form := http.Header{"Cookie": {cookie}, "Content-Type": {"application/x-www-form-urlencoded"}}
+	if recorder := send(http.MethodPost, form, "csrf_token="+token); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the form field to be accepted, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodPost, http.Header{"Cookie": {cookie}}, ""); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected the request without token to be refused, got %d", recorder.Code)
+	}
+	if recorder := send(http.MethodPost, http.Header{"Cookie": {cookie}, CSRFTokenKey: {token}, "Origin": {"https://evil.com"}}, ""); recorder.Code != http.StatusForbidden ||
+		!strings.Contains(recorder.Body.String(), "cross-site") {
+		t.Fatalf("expected the cross-site request to be refused, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send(http.MethodPost, http.Header{"Cookie": {cookie}, AuthorizationKey: {"token"}}, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the bearer request to be exempt, got %d", recorder.Code)
+	}
+
+	if srv.csrf.valid(srv.csrf.issue("1"), "") {
+		t.Fatal("expected the token of an account to be refused for another session")
+	}
+}
+
+func TestCSRFSession(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny, InternalSecret: "internal"}, tokens,
+		"--http.csrf", "--http.csrf.secret", "secret", "--http.session", "--http.session.insecure",
+		"--http.security", "--http.security.csp_report")
+	srv.Post("/login", &Handler{Policy: authorities.AuthorizationPolicyAllow, Func: func(ctx *Context) error {
+		return srv.Login(ctx, authorities.NewAuthorized("1", "alice", nil, nil))
+	}})
+	srv.Post("/orders", &Handler{Func: func(ctx *Context) error {
+		ctx.WriteData(ctx.Authorized.Account)
+		return nil
+	}})
+	cookies := map[string]string{}
+	send := func(method string, target string, header ...string) *httptest.ResponseRecorder {
+		var jar []string
+		for name, value := range cookies {
+			jar = append(jar, name+"="+value)
+		}
+		recorder := serve(srv, method, target, append(header, "Cookie", strings.Join(jar, "; "))...)
+		for _, cookie := range recorder.Result().Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		return recorder
+	}
+
+	if recorder := send(http.MethodPost, "/login"); recorder.Code != http.StatusOK || cookies["session"] == "" {
+		t.Fatalf("expected the session cookie, got %d %v", recorder.Code, cookies)
+	}
+	recorder := send(http.MethodGet, "/csrf-token")
+	var reply struct {
+		Content csrfTokenReply `json:"content"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &reply); err != nil || reply.Content.Token != cookies["csrf_token"] {
+		t.Fatalf("expected the token of the cookie, got %s %v", recorder.Body.String(), cookies)
+	}
+	if recorder := send(http.MethodPost, "/orders", CSRFTokenKey, reply.Content.Token); recorder.Code != http.StatusOK ||
+		!strings.Contains(recorder.Body.String(), "alice") {
+		t.Fatalf("expected the token of the session to be accepted, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send(http.MethodPost, "/csp-report", "Content-Type", "application/csp-report"); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected the csp reports to be exempt, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// only the valid internal secret exempts the request
+	if recorder := send(http.MethodPost, "/orders", InternalSecretKey, "wrong"); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected a wrong internal secret to be checked, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send(http.MethodPost, "/orders", InternalSecretKey, "internal"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the internal secret to be exempt, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// the token of a session is refused for another session of the same account
+	first := reply.Content.Token
+	if recorder := send(http.MethodPost, "/login", CSRFTokenKey, first); recorder.Code != http.StatusOK || cookies["csrf_token"] == first {
+		t.Fatalf("expected a token for the new session, got %d %v", recorder.Code, cookies)
+	}
+	cookies["csrf_token"] = first
+	if recorder := send(http.MethodPost, "/orders", CSRFTokenKey, first); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected the token of the previous session to be refused, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed docs
+var docsAssets embed.FS
+
+var docsTemplate = template.Must(template.ParseFS(docsAssets, "docs/index.html"))
+
+type docsConfig struct {
+	secret   bool
+	profiles []string
+}
+
+type DocsOption func(*docsConfig)
+
+// DocsRequireSecret serves the docs only to requests carrying the internal secret,
+// such as the ones forwarded by an internal gateway. The docs are refused when no secret is configured.
+func DocsRequireSecret() DocsOption {
+	return func(c *docsConfig) {
+		c.secret = true
+	}
+}
+
+// DocsExcludeProfiles disables the docs when the application runs with one of the profiles.
+func DocsExcludeProfiles(profiles ...string) DocsOption {
+	return func(c *docsConfig) {
+		c.profiles = append(c.profiles, profiles...)
+	}
+}
+
+type docsSpec struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Internal bool   `json:"internal"`
+}
+
+// docsPage is read by the explorer to find the specs and the headers of the "try it" requests.
+type docsPage struct {
+	Title             string     `json:"title"`
+	Assets            string     `json:"assets"`
+	Specs             []docsSpec `json:"specs"`
+	AuthorizationKey  string     `json:"authorization_key"`
+	InternalSecretKey string     `json:"internal_secret_key"`
+	// Nonce is the nonce of the Content-Security-Policy of the request
+	Nonce string `json:"-"`
+}
+
+// Docs serves an interactive explorer of the OpenAPI specs at the path, such as /docs.
+// The assets are embedded in the binary, nothing is fetched from a CDN.
+func (m *HttpServer) Docs(path string, opts ...DocsOption) {
+	config := &docsConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if slices.Contains(config.profiles, m.profile) {
+		m.logger.Info("api docs disabled", "profile", m.profile)
+		return
+	}
+
+	path, _ = url.JoinPath("/", m.path, path)
+	assets, _ := fs.Sub(docsAssets, "docs/assets")
+	files := http.StripPrefix(path+"/assets", http.FileServer(http.FS(assets)))
+
+	if config.secret && m.authorization.Settings().InternalSecret == "" {
+		m.logger.Warn("api docs require the internal secret but none is configured, they are refused", "path", path)
+	}
+	allowed := func(ctx *gin.Context) bool {
+		if config.secret && !m.internalSecret(ctx.Request) {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	m.engine.GET(path, func(ctx *gin.Context) {
+		if !allowed(ctx) {
+			return
+		}
+		ctx.Header("content-type", "text/html; charset=utf-8")
+		page := m.docsPage(path)
+		page.Nonce = ctx.GetString(cspNonceContextKey)
+		if err := docsTemplate.Execute(ctx.Writer, page); err != nil {
+			m.logger.Error("render api docs", "path", path, "err", err)
+		}
+	})
+	m.engine.GET(path+"/assets/*file", func(ctx *gin.Context) {
+		if !allowed(ctx) {
+			return
+		}
+		files.ServeHTTP(ctx.Writer, ctx.Request)
+	})
+}
+
+// docsPage lists the specs when the page is requested, so versions added after Docs are included.
+func (m *HttpServer) docsPage(path string) *docsPage {
+	spec := func(name string) string {
+		spec, _ := url.JoinPath("/", m.path, name)
+		return spec
+	}
+
+	page := &docsPage{
+		Title:             m.reflector.Spec.Info.Title,
+		Assets:            path + "/assets",
+		AuthorizationKey:  AuthorizationKey,
+		InternalSecretKey: InternalSecretKey,
+	}
+	page.Specs = append(page.Specs, docsSpec{Name: m.reflector.Spec.Info.Title, URL: spec("openapi.json")})
+
+	names := make([]string, 0, len(m.versions))
+	for name := range m.versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		page.Specs = append(page.Specs, docsSpec{Name: name, URL: spec("openapi/" + name + ".json")})
+	}
+
+	// the internal spec is only served with the internal secret
+	if m.authorization.Settings().InternalSecret != "" {
+		page.Specs = append(page.Specs, docsSpec{
+			Name:     m.internalReflector.Spec.Info.Title,
+			URL:      spec("internal/openapi.json"),
+			Internal: true,
+		})
+	}
+	return page
+}
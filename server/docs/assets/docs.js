@@ -0,0 +1,289 @@
+(function () {
+	"use strict";
+
+	var config = JSON.parse(document.getElementById("config").textContent);
+	var methods = ["get", "post", "put", "patch", "delete", "head", "options"];
+	var spec = null;
+
+	function el(tag, attrs, children) {
+		var node = document.createElement(tag);
+		Object.keys(attrs || {}).forEach(function (key) {
+			if (key === "text") {
+				node.textContent = attrs[key];
+			} else if (key === "class") {
+				node.className = attrs[key];
+			} else {
+				node.setAttribute(key, attrs[key]);
+			}
+		});
+		(children || []).forEach(function (child) {
+			if (child) {
+				node.appendChild(child);
+			}
+		});
+		return node;
+	}
+
+	function resolve(schema) {
+		var depth = 0;
+		while (schema && schema.$ref && depth++ < 16) {
+			var name = schema.$ref.replace("#/components/schemas/", "");
+			schema = ((spec.components || {}).schemas || {})[name];
+		}
+		return schema || {};
+	}
+
+	// example builds a sample value of the schema to prefill the request body
+	function example(schema, depth) {
+		schema = resolve(schema);
+		if (depth > 6) {
+			return null;
+		}
+		if (schema.example !== undefined) {
+			return schema.example;
+		}
+		if (schema.default !== undefined) {
+			return schema.default;
+		}
+		if (schema.enum) {
+			return schema.enum[0];
+		}
+		if (schema.allOf) {
+			return schema.allOf.reduce(function (value, part) {
+				return Object.assign(value, example(part, depth + 1));
+			}, {});
+		}
+		if (schema.oneOf || schema.anyOf) {
+			return example((schema.oneOf || schema.anyOf)[0], depth + 1);
+		}
+		switch (schema.type) {
+		case "object":
+			var value = {};
+			Object.keys(schema.properties || {}).forEach(function (key) {
+				value[key] = example(schema.properties[key], depth + 1);
+			});
+			return value;
+		case "array":
+			return [example(schema.items, depth + 1)];
+		case "integer":
+		case "number":
+			return 0;
+		case "boolean":
+			return false;
+		case "string":
+			return schema.format === "date-time" ? new Date().toISOString() : "";
+		}
+		return schema.properties ? example(Object.assign({type: "object"}, schema), depth) : null;
+	}
+
+	function secured(operation) {
+		var security = operation.security || spec.security || [];
+		return security.some(function (requirement) {
+			return Object.keys(requirement).length > 0;
+		});
+	}
+
+	function requires(operation, scheme) {
+		return (operation.security || spec.security || []).some(function (requirement) {
+			return requirement.hasOwnProperty(scheme);
+		});
+	}
+
+	function headers(internal) {
+		var values = {};
+		var authorization = document.getElementById("authorization").value;
+		var secret = document.getElementById("secret").value;
+		if (authorization) {
+			values[config.authorization_key] = authorization;
+		}
+		if (secret && internal) {
+			values[config.internal_secret_key] = secret;
+		}
+		return values;
+	}
+
+	function server() {
+		var url = ((spec.servers || [])[0] || {}).url || "/";
+		return url.replace(/\/$/, "");
+	}
+
+	function send(method, path, operation, inputs, body, output) {
+		var url = server() + path.replace(/{([^}]+)}/g, function (_, name) {
+			return encodeURIComponent(inputs.path[name] ? inputs.path[name].value : "");
+		});
+		var query = new URLSearchParams();
+		Object.keys(inputs.query).forEach(function (name) {
+			if (inputs.query[name].value !== "") {
+				query.append(name, inputs.query[name].value);
+			}
+		});
+		if (query.toString()) {
+			url += "?" + query.toString();
+		}
+
+		var init = {method: method.toUpperCase(), headers: headers(requires(operation, "internalSecret"))};
+		Object.keys(inputs.header).forEach(function (name) {
+			if (inputs.header[name].value !== "") {
+				init.headers[name] = inputs.header[name].value;
+			}
+		});
+		if (body) {
+			if (body.type === "application/x-www-form-urlencoded") {
+				try {
+					var fields = JSON.parse(body.input.value || "{}");
+					init.body = new URLSearchParams(fields).toString();
+				} catch (e) {
+					output.textContent = "invalid body: " + e.message;
+					return;
+				}
+			} else {
+				init.body = body.input.value;
+			}
+			init.headers["Content-Type"] = body.type;
+		}
+
+		var started = Date.now();
+		output.textContent = "...";
+		fetch(url, init).then(function (response) {
+			return response.text().then(function (text) {
+				var lines = [init.method + " " + url, response.status + " " + response.statusText + " (" + (Date.now() - started) + " ms)"];
+				response.headers.forEach(function (value, name) {
+					lines.push(name + ": " + value);
+				});
+				try {
+					text = JSON.stringify(JSON.parse(text), null, 2);
+				} catch (e) {
+					// not json, shown as is
+				}
+				output.textContent = lines.join("\n") + "\n\n" + text;
+			});
+		}).catch(function (e) {
+			output.textContent = "request failed: " + e.message;
+		});
+	}
+
+	function renderOperation(method, path, operation) {
+		var inputs = {path: {}, query: {}, header: {}};
+		var rows = (operation.parameters || []).filter(function (parameter) {
+			return inputs.hasOwnProperty(parameter.in);
+		}).map(function (parameter) {
+			var input = el("input", {placeholder: (parameter.schema || {}).type || ""});
+			inputs[parameter.in][parameter.name] = input;
+			return el("tr", {}, [
+				el("td", {class: parameter.required ? "required" : "", text: parameter.name}),
+				el("td", {text: parameter.in}),
+				el("td", {}, [input]),
+				el("td", {text: parameter.description || ""})
+			]);
+		});
+
+		var body = null;
+		var content = (operation.requestBody || {}).content || {};
+		var type = Object.keys(content)[0];
+		if (type) {
+			var textarea = el("textarea");
+			textarea.value = JSON.stringify(example(content[type].schema, 0), null, 2);
+			body = {type: type, input: textarea};
+		}
+
+		var responses = Object.keys(operation.responses || {}).map(function (status) {
+			return el("tr", {}, [
+				el("td", {text: status}),
+				el("td", {text: operation.responses[status].description || ""})
+			]);
+		});
+
+		var output = el("pre", {text: ""});
+		var button = el("button", {text: "Try it"});
+		button.addEventListener("click", function () {
+			send(method, path, operation, inputs, body, output);
+		});
+
+		var summary = el("div", {class: "summary" + (secured(operation) ? " secured" : "")}, [
+			el("span", {class: "method " + method, text: method.toUpperCase()}),
+			el("span", {class: "path", text: path}),
+			el("span", {text: operation.summary || ""})
+		]);
+		var node = el("div", {class: "operation"}, [
+			summary,
+			el("div", {class: "details"}, [
+				operation.description ? el("p", {text: operation.description}) : null,
+				rows.length ? el("table", {}, [el("tr", {}, [
+					el("th", {text: "Parameter"}), el("th", {text: "In"}), el("th", {text: "Value"}), el("th", {text: ""})
+				])].concat(rows)) : null,
+				body ? el("p", {text: "Body (" + body.type + ")"}) : null,
+				body ? body.input : null,
+				el("p", {text: "Responses"}),
+				el("table", {}, responses),
+				button,
+				output
+			])
+		]);
+		summary.addEventListener("click", function () {
+			node.classList.toggle("open");
+		});
+		return node;
+	}
+
+	function render() {
+		var container = document.getElementById("operations");
+		container.textContent = "";
+		document.getElementById("title").textContent = spec.info.title + " " + (spec.info.version || "");
+		document.getElementById("description").textContent = spec.info.description || "";
+
+		var groups = {};
+		var order = (spec.tags || []).map(function (tag) {
+			return tag.name;
+		});
+		Object.keys(spec.paths || {}).sort().forEach(function (path) {
+			methods.forEach(function (method) {
+				var operation = spec.paths[path][method];
+				if (!operation) {
+					return;
+				}
+				var tag = (operation.tags || [])[0] || "default";
+				if (order.indexOf(tag) < 0) {
+					order.push(tag);
+				}
+				(groups[tag] = groups[tag] || []).push(renderOperation(method, path, operation));
+			});
+		});
+		order.forEach(function (tag) {
+			if (groups[tag]) {
+				container.appendChild(el("h2", {text: tag}));
+				groups[tag].forEach(function (node) {
+					container.appendChild(node);
+				});
+			}
+		});
+	}
+
+	function load() {
+		var selected = config.specs[document.getElementById("spec").value];
+		var container = document.getElementById("operations");
+		fetch(selected.url, {headers: headers(selected.internal)}).then(function (response) {
+			if (!response.ok) {
+				throw new Error(response.status + " " + response.statusText);
+			}
+			return response.json();
+		}).then(function (value) {
+			spec = value;
+			render();
+		}).catch(function (e) {
+			container.textContent = "";
+			container.appendChild(el("p", {class: "error", text: "failed to load " + selected.url + ": " + e.message}));
+		});
+	}
+
+	var select = document.getElementById("spec");
+	config.specs.forEach(function (item, i) {
+		select.appendChild(el("option", {value: String(i), text: item.name}));
+	});
+	select.addEventListener("change", load);
+	document.getElementById("secret").addEventListener("change", function () {
+		if (config.specs[select.value].internal) {
+			load();
+		}
+	});
+	load();
+})();
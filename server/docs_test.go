@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+)
+
+func TestDocs(t *testing.T) {
+	srv := newTestServer(t, &authorities.Settings{InternalSecret: "secret"}, nil)
+	srv.Docs("/docs", DocsRequireSecret())
+	if recorder := serve(srv, http.MethodGet, "/docs"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the docs to need the secret, got %d", recorder.Code)
+	}
+	if recorder := serve(srv, http.MethodGet, "/docs/assets/docs.js"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the assets to need the secret, got %d", recorder.Code)
+	}
+	page := serve(srv, http.MethodGet, "/docs", InternalSecretKey, "secret")
+	if page.Code != http.StatusOK || !strings.HasPrefix(page.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(page.Body.String(), `"url":"/openapi.json"`) || !strings.Contains(page.Body.String(), `"url":"/internal/openapi.json"`) {
+		t.Fatalf("unexpected page %d %s", page.Code, page.Body.String())
+	}
+	if recorder := serve(srv, http.MethodGet, "/docs/assets/docs.js", InternalSecretKey, "secret"); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the assets to be served, got %d", recorder.Code)
+	}
+
+	// without an internal secret the docs requiring it are refused, and the internal spec isn't linked
+	srv = newTestServer(t, &authorities.Settings{}, nil)
+	srv.Docs("/docs", DocsRequireSecret())
+	srv.Docs("/explorer")
+	if recorder := serve(srv, http.MethodGet, "/docs"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the docs refused without a secret configured, got %d", recorder.Code)
+	}
+	page = serve(srv, http.MethodGet, "/explorer")
+	if page.Code != http.StatusOK || strings.Contains(page.Body.String(), "internal/openapi.json") {
+		t.Fatalf("expected the page without the internal spec, got %d %s", page.Code, page.Body.String())
+	}
+	if recorder := serve(srv, http.MethodGet, "/internal/openapi.json"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the internal spec refused without a secret configured, got %d", recorder.Code)
+	}
+
+	srv = newTestServer(t, &authorities.Settings{}, nil)
+	srv.Docs("/docs", DocsExcludeProfiles("test"))
+	if recorder := serve(srv, http.MethodGet, "/docs"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected the docs disabled for the profile, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+)
+
+var errDownloadURL = errors.New("invalid or expired download url")
+
+// ServeFile writes the file of the storage with the Range, If-Range, ETag, If-None-Match and
+// If-Modified-Since support of http.ServeContent, the ETag is the checksum of the file.
+// Images, audio and video are displayed inline, the other files are downloaded as attachments.
+// ErrFileNotFound is returned when there is no such file.
+// The transfer of a large file outlasts the http.handler_timeout of the server, the route opts out
+// of it with a negative Handler.Timeout as the ones of Downloads do.
+func (m *HttpServer) ServeFile(ctx *Context, storage Storage, key string) error {
+	file, info, err := storage.Open(ctx.Request.Context(), key)
+	if err != nil {
+		if !errors.Is(err, ErrFileNotFound) {
+			m.logger.Warn("open file failed", "key", key, "err", err)
+		}
+		return err
+	}
+	defer file.Close()
+
+	header := ctx.Writer.Header()
+	if info.Checksum != "" {
+		header.Set("ETag", `"`+info.Checksum+`"`)
+	} else {
+		header.Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.Modified.UnixNano(), info.Size))
+	}
+	if info.ContentType != "" {
+		header.Set("Content-Type", info.ContentType)
+	}
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition(info.ContentType), map[string]string{"filename": info.Name}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(ctx.Writer, ctx.Request, info.Name, info.Modified, file)
+	return nil
+}
+
+// disposition displays inline the types a browser can't run scripts in, SVG may hold scripts.
+func disposition(contentType string) string {
+	media, _, _ := mime.ParseMediaType(contentType)
+	if media != "image/svg+xml" && (strings.HasPrefix(media, "image/") || strings.HasPrefix(media, "audio/") ||
+		strings.HasPrefix(media, "video/")) {
+		return "inline"
+	}
+	return "attachment"
+}
+
+// urlSigner signs the download URLs with HMAC-SHA256.
+type urlSigner struct {
+	key *signingKey
+	ttl time.Duration
+}
+
+func newURLSigner(opts *option.Download) *urlSigner {
+	signer := &urlSigner{key: newSigningKey(opts.Secret, "http.download.secret"), ttl: time.Duration(opts.TTL) * time.Second}
+	if signer.ttl <= 0 {
+		signer.ttl = time.Hour
+	}
+	return signer
+}
+
+func (s *urlSigner) sign(path string, expires int64, ip string) string {
+	mac := s.key.mac()
+	mac.Write([]byte(path))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(ip))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// valid checks the signature of the path, signed for any client or for the ip of the request.
+func (s *urlSigner) valid(path string, query url.Values, ip string) bool {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	signature := []byte(query.Get("signature"))
+	return hmac.Equal(signature, []byte(s.sign(path, expires, ""))) ||
+		hmac.Equal(signature, []byte(s.sign(path, expires, ip)))
+}
+
+// Downloads serves the files of a storage without authorization to the holders of the URLs it signed.
+type Downloads struct {
+	path    string
+	storage Storage
+	signer  *urlSigner
+}
+
+type downloadArgs struct {
+	Expires   int64  `query:"expires" description:"Unix time the URL expires at"`
+	Signature string `query:"signature" description:"Signature of the URL"`
+}
+
+// Downloads registers GET and HEAD routes under the path serving the files of the storage
+// to the URLs returned by SignedURL, the key of the file is the rest of the path.
+func (m *HttpServer) Downloads(path string, storage Storage) *Downloads {
+	m.downloadSigner.key.warn(m.logger)
+	prefix, _ := url.JoinPath("/", m.path, path)
+	downloads := &Downloads{path: prefix, storage: storage, signer: m.downloadSigner}
+	handler := &Handler{
+		Name:    "download",
+		Tags:    []string{"files"},
+		Policy:  authorities.AuthorizationPolicyAllow,
+		Args:    &downloadArgs{},
+		Timeout: -1,
+		Func: func(ctx *Context) error {
+			if !downloads.signer.valid(ctx.Request.URL.Path, ctx.Request.URL.Query(), m.ClientIP(ctx)) {
+				ctx.Negotiate(http.StatusForbidden, &Response{
+					Code:      http.StatusForbidden,
+					Message:   errDownloadURL.Error(),
+					Timestamp: time.Now().Local().Unix(),
+				})
+				return nil
+			}
+			expires, _ := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+			ctx.Writer.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", max(expires-time.Now().Unix(), 0)))
+			return m.ServeFile(ctx, downloads.storage, strings.TrimPrefix(ctx.Param("key"), "/"))
+		},
+	}
+	m.Get(path+"/*key", handler)
+	m.Head(path+"/*key", handler)
+	return downloads
+}
+
+// SignedURL returns the path and the query of the URL giving access to the file until the ttl
+// elapsed, the ttl of the settings when 0. The URL is bound to the client ip when not empty,
+// such as the HttpServer.ClientIP of the request it is given to.
+func (d *Downloads) SignedURL(key string, ttl time.Duration, ip string) string {
+	if ttl <= 0 {
+		ttl = d.signer.ttl
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	// the signature covers the path as the request sends it
+	u, _ := url.Parse(d.path + "/" + strings.Join(segments, "/"))
+	expires := time.Now().Add(ttl).Unix()
+	u.RawQuery = url.Values{
+		"expires":   {strconv.FormatInt(expires, 10)},
+		"signature": {d.signer.sign(u.Path, expires, ip)},
+	}.Encode()
+	return u.String()
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+)
+
+func TestDownloads(t *testing.T) {
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, nil,
+		"--http.download.secret", "secret", "--http.download.ttl", "60")
+	storage := NewMemoryStorage()
+	content := strings.Repeat("0123456789", 10)
+	storage.Put(context.Background(), "reports/q1 2024.csv", strings.NewReader(content), &FileInfo{Name: "q1 2024.csv", ContentType: "text/csv", Checksum: "abc"})
+	downloads := srv.Downloads("files", storage)
+
+	send := func(target string, header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.RemoteAddr = "10.0.0.1:1234"
+		for name, values := range header {
+			request.Header.Set(name, values[0])
+		}
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	signed := downloads.SignedURL("reports/q1 2024.csv", 0, "")
+	recorder := send(signed, nil)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != content || recorder.Header().Get("ETag") != `"abc"` ||
+		!strings.HasPrefix(recorder.Header().Get("Content-Disposition"), "attachment") {
+		t.Fatalf("unexpected response %d %v", recorder.Code, recorder.Header())
+	}
+	if recorder := send(signed, http.Header{"Range": {"bytes=10-19"}}); recorder.Code != http.StatusPartialContent || recorder.Body.String() != "0123456789" {
+		t.Fatalf("expected the range, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send(signed, http.Header{"If-None-Match": {`"abc"`}}); recorder.Code != http.StatusNotModified {
+		t.Fatalf("expected not modified, got %d", recorder.Code)
+	}
+
+	if recorder := send(strings.Replace(signed, "q1", "q2", 1), nil); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected another file to be refused, got %d", recorder.Code)
+	}
+	if recorder := send(downloads.SignedURL("reports/q1 2024.csv", -time.Minute, ""), nil); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the default ttl, got %d", recorder.Code)
+	}
+	bound := downloads.SignedURL("reports/q1 2024.csv", time.Minute, "10.0.0.2")
+	if recorder := send(bound, nil); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected the url bound to another ip to be refused, got %d", recorder.Code)
+	}
+	if recorder := send(bound, http.Header{"X-Forwarded-For": {"10.0.0.2"}}); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected the forwarded address of a proxy not trusted to be ignored, got %d", recorder.Code)
+	}
+	if recorder := send(downloads.SignedURL("reports/q1 2024.csv", time.Minute, "10.0.0.1"), nil); recorder.Code != http.StatusOK {
+		t.Fatalf("expected the url bound to the ip to be accepted, got %d", recorder.Code)
+	}
+	if recorder := send(downloads.SignedURL("reports/missing.csv", time.Minute, ""), nil); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, got %d", recorder.Code)
+	}
+
+	expires := time.Now().Add(-time.Second).Unix()
+	query := url.Values{"expires": {strconv.FormatInt(expires, 10)}, "signature": {srv.downloadSigner.sign("/files/a.csv", expires, "")}}
+	if srv.downloadSigner.valid("/files/a.csv", query, "") {
+		t.Fatal("expected the expired url to be refused")
+	}
+}
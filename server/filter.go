@@ -0,0 +1,625 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	filterContextKey = "core.filter"
+	// FilterParam is the query parameter of the filter expression
+	FilterParam = "filter"
+
+	maxFilterConditions = 32
+	maxFilterValues     = 100
+)
+
+// FilterOperator is the comparison of a condition.
+type FilterOperator string
+
+const (
+	FilterEq       FilterOperator = "eq"
+	FilterNe       FilterOperator = "ne"
+	FilterGt       FilterOperator = "gt"
+	FilterGe       FilterOperator = "ge"
+	FilterLt       FilterOperator = "lt"
+	FilterLe       FilterOperator = "le"
+	FilterIn       FilterOperator = "in"
+	FilterContains FilterOperator = "contains"
+)
+
+var filterOperators = []FilterOperator{FilterEq, FilterNe, FilterGt, FilterGe, FilterLt, FilterLe, FilterIn, FilterContains}
+
+var filterSQL = map[FilterOperator]string{
+	FilterEq: " = ?", FilterNe: " <> ?", FilterGt: " > ?", FilterGe: " >= ?", FilterLt: " < ?", FilterLe: " <= ?",
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// FilterNode is a node of the filter AST, a *FilterGroup or a *FilterCondition.
+type FilterNode interface {
+	where(*strings.Builder, *[]any)
+}
+
+// FilterGroup joins its nodes with AND, or with OR when Or is set.
+type FilterGroup struct {
+	Or    bool
+	Nodes []FilterNode
+}
+
+// FilterCondition compares a field to its values, typed after the field of the args.
+// Values holds a single value except for in, a nil value compares to NULL.
+type FilterCondition struct {
+	Field    string
+	Column   string
+	Operator FilterOperator
+	Values   []any
+}
+
+// Filter is the filter of a list request, the AND of the equality query parameters of the
+// filterable fields and of the expression of the filter parameter:
+//
+//	?status=paid&filter=amount ge 100 and (country in ('FR', 'DE') or name contains 'shop')
+//
+// The operators are eq, ne, gt, ge, lt, le, in and contains, AND binds tighter than OR.
+// Strings are quoted with ', which is doubled inside them, numbers, booleans and times
+// (RFC 3339 or 2006-01-02) may be bare, null compares to NULL with eq and ne.
+//
+// The fields are the args fields with a filter tag listing the operators allowed, or * for all:
+//
+//	Status string    `query:"status" filter:"eq,in"`
+//	Amount int64     `query:"amount" db:"total_amount" filter:"*"`
+//
+// The field is named by its query, form or json tag and is the column of the db tag, or its name.
+type Filter struct {
+	Root *FilterGroup
+}
+
+// Where returns the condition of the filter for a WHERE clause of MySQL, without the keyword,
+// and its arguments bound with ? placeholders. The condition is empty when nothing is filtered.
+func (f *Filter) Where() (string, []any) {
+	if nil == f || nil == f.Root || len(f.Root.Nodes) == 0 {
+		return "", nil
+	}
+	var sql strings.Builder
+	var args []any
+	f.Root.where(&sql, &args)
+	return sql.String(), args
+}
+
+// Conditions returns the conditions on the field, for the stores other than SQL.
+func (f *Filter) Conditions(field string) []*FilterCondition {
+	var conditions []*FilterCondition
+	var walk func(FilterNode)
+	walk = func(node FilterNode) {
+		switch node := node.(type) {
+		case *FilterGroup:
+			for _, child := range node.Nodes {
+				walk(child)
+			}
+		case *FilterCondition:
+			if node.Field == field {
+				conditions = append(conditions, node)
+			}
+		}
+	}
+	if nil != f && nil != f.Root {
+		walk(f.Root)
+	}
+	return conditions
+}
+
+func (g *FilterGroup) where(sql *strings.Builder, args *[]any) {
+	join := " AND "
+	if g.Or {
+		join = " OR "
+	}
+	for i, node := range g.Nodes {
+		if i > 0 {
+			sql.WriteString(join)
+		}
+		if group, ok := node.(*FilterGroup); ok && len(group.Nodes) > 1 {
+			sql.WriteString("(")
+			group.where(sql, args)
+			sql.WriteString(")")
+			continue
+		}
+		node.where(sql, args)
+	}
+}
+
+func (c *FilterCondition) where(sql *strings.Builder, args *[]any) {
+	sql.WriteString(quoteColumn(c.Column))
+	switch {
+	case c.Operator == FilterIn:
+		sql.WriteString(" IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(c.Values)), ", ") + ")")
+		*args = append(*args, c.Values...)
+	case c.Operator == FilterContains:
+		// the backslash is the default escape character of LIKE in MySQL
+		sql.WriteString(" LIKE ?")
+		*args = append(*args, "%"+likeEscaper.Replace(fmt.Sprint(c.Values[0]))+"%")
+	case nil == c.Values[0] && c.Operator == FilterEq:
+		sql.WriteString(" IS NULL")
+	case nil == c.Values[0] && c.Operator == FilterNe:
+		sql.WriteString(" IS NOT NULL")
+	default:
+		sql.WriteString(filterSQL[c.Operator])
+		*args = append(*args, c.Values[0])
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// quoteColumn quotes the parts of a column such as t.status with backticks.
+func quoteColumn(column string) string {
+	parts := strings.Split(column, ".")
+	for i, part := range parts {
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "``") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+// filterField is a filterable field of the args.
+type filterField struct {
+	name      string
+	column    string
+	kind      reflect.Type
+	operators []FilterOperator
+}
+
+// filterFields returns the filterable fields of the args by name, nil when there are none.
+func filterFields(args any) map[string]*filterField {
+	t := reflect.TypeOf(args)
+	if nil == t {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	fields := make(map[string]*filterField)
+	collectFilterFields(t, fields)
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+func collectFilterFields(t reflect.Type, fields map[string]*filterField) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			collectFilterFields(field.Type, fields)
+			continue
+		}
+		tag, ok := field.Tag.Lookup("filter")
+		if !ok || tag == "-" {
+			continue
+		}
+		name := field.Name
+		for _, key := range []string{"query", "form", "json"} {
+			if value, _, _ := strings.Cut(field.Tag.Get(key), ","); value != "" && value != "-" {
+				name = value
+				break
+			}
+		}
+		column, _, _ := strings.Cut(field.Tag.Get("db"), ",")
+		if column == "" || column == "-" {
+			column = name
+		}
+		kind := field.Type
+		for kind.Kind() == reflect.Ptr || kind.Kind() == reflect.Slice {
+			kind = kind.Elem()
+		}
+		filterable := &filterField{name: name, column: column, kind: kind}
+		for _, operator := range strings.Split(tag, ",") {
+			operator = strings.ToLower(strings.TrimSpace(operator))
+			if operator == "*" {
+				filterable.operators = slices.Clone(filterOperators)
+				break
+			}
+			if slices.Contains(filterOperators, FilterOperator(operator)) {
+				filterable.operators = append(filterable.operators, FilterOperator(operator))
+			}
+		}
+		// contains applies to the strings only
+		if kind.Kind() != reflect.String {
+			filterable.operators = slices.DeleteFunc(filterable.operators, func(operator FilterOperator) bool {
+				return operator == FilterContains
+			})
+		}
+		fields[name] = filterable
+	}
+}
+
+// value converts a literal to the type of the field.
+func (f *filterField) value(literal string, quoted bool) (any, error) {
+	if !quoted && strings.EqualFold(literal, "null") {
+		return nil, nil
+	}
+	if f.kind == timeType {
+		for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+			if parsed, err := time.Parse(layout, literal); err == nil {
+				return parsed, nil
+			}
+		}
+		return nil, fmt.Errorf("%s expects a time, got %q", f.name, literal)
+	}
+	var value any
+	var err error
+	switch f.kind.Kind() {
+	case reflect.String:
+		value = literal
+	case reflect.Bool:
+		value, err = strconv.ParseBool(literal)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err = strconv.ParseInt(literal, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err = strconv.ParseUint(literal, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		value, err = strconv.ParseFloat(literal, 64)
+	default:
+		value = literal
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s expects a %s, got %q", f.name, f.kind.Kind(), literal)
+	}
+	return value, nil
+}
+
+// ParseFilter parses the expression of a filter parameter, the fields are the filterable fields of the args.
+func ParseFilter(expression string, args any) (*Filter, error) {
+	root, err := parseFilter(expression, filterFields(args))
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Root: root}, nil
+}
+
+func parseFilter(expression string, fields map[string]*filterField) (*FilterGroup, error) {
+	tokens, err := lexFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	parser := &filterParser{tokens: tokens, fields: fields}
+	root := &FilterGroup{}
+	if len(tokens) == 0 {
+		return root, nil
+	}
+	node, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(tokens) {
+		return nil, parser.errorf("unexpected %q", tokens[parser.pos].text)
+	}
+	if group, ok := node.(*FilterGroup); ok && !group.Or {
+		return group, nil
+	}
+	root.Nodes = append(root.Nodes, node)
+	return root, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+// lexFilter splits the expression into parentheses, commas, quoted strings and words.
+func lexFilter(expression string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expression); {
+		switch c := expression[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, filterToken{text: string(c), pos: i})
+			i++
+		case c == '\'':
+			var text strings.Builder
+			start := i
+			for i++; ; i++ {
+				if i >= len(expression) {
+					return nil, fmt.Errorf("filter: unterminated string at %d", start)
+				}
+				if expression[i] == '\'' {
+					if i+1 < len(expression) && expression[i+1] == '\'' {
+						text.WriteByte('\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				text.WriteByte(expression[i])
+			}
+			tokens = append(tokens, filterToken{text: text.String(), quoted: true, pos: start})
+		default:
+			start := i
+			for i < len(expression) && !strings.ContainsRune(" \t\r\n(),'", rune(expression[i])) {
+				i++
+			}
+			tokens = append(tokens, filterToken{text: expression[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens     []filterToken
+	pos        int
+	fields     map[string]*filterField
+	conditions int
+	depth      int
+}
+
+func (p *filterParser) errorf(format string, args ...any) error {
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("filter: "+format+" at the end", args...)
+	}
+	return fmt.Errorf("filter: "+format+" at %d", append(args, p.tokens[p.pos].pos)...)
+}
+
+func (p *filterParser) keyword(word string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, p.errorf("unexpected end")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+// or parses the terms joined by OR.
+func (p *filterParser) or() (FilterNode, error) {
+	return p.join(true, p.and)
+}
+
+// and parses the factors joined by AND.
+func (p *filterParser) and() (FilterNode, error) {
+	return p.join(false, p.factor)
+}
+
+func (p *filterParser) join(or bool, parse func() (FilterNode, error)) (FilterNode, error) {
+	word := "and"
+	if or {
+		word = "or"
+	}
+	group := &FilterGroup{Or: or}
+	for {
+		node, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		// the nested groups of the same operator are flattened
+		if nested, ok := node.(*FilterGroup); ok && nested.Or == or {
+			group.Nodes = append(group.Nodes, nested.Nodes...)
+		} else {
+			group.Nodes = append(group.Nodes, node)
+		}
+		if !p.keyword(word) {
+			break
+		}
+	}
+	if len(group.Nodes) == 1 {
+		return group.Nodes[0], nil
+	}
+	return group, nil
+}
+
+// factor parses a parenthesized expression or a condition.
+func (p *filterParser) factor() (FilterNode, error) {
+	if p.keyword("(") {
+		if p.depth++; p.depth > 8 {
+			return nil, p.errorf("too deeply nested")
+		}
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, p.errorf("expected )")
+		}
+		p.depth--
+		return node, nil
+	}
+
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	field, ok := p.fields[token.text]
+	if !ok || token.quoted {
+		p.pos--
+		return nil, p.errorf("unknown field %q", token.text)
+	}
+	token, err = p.next()
+	if err != nil {
+		return nil, err
+	}
+	operator := FilterOperator(strings.ToLower(token.text))
+	if !slices.Contains(filterOperators, operator) || token.quoted {
+		p.pos--
+		return nil, p.errorf("unknown operator %q", token.text)
+	}
+	if !slices.Contains(field.operators, operator) {
+		p.pos--
+		return nil, p.errorf("%s is not allowed on %s", operator, field.name)
+	}
+	if p.conditions++; p.conditions > maxFilterConditions {
+		return nil, p.errorf("more than %d conditions", maxFilterConditions)
+	}
+	condition := &FilterCondition{Field: field.name, Column: field.column, Operator: operator}
+
+	if operator != FilterIn {
+		value, err := p.value(field)
+		if err != nil {
+			return nil, err
+		}
+		if nil == value && operator != FilterEq && operator != FilterNe {
+			return nil, p.errorf("null can't be compared with %s", operator)
+		}
+		condition.Values = []any{value}
+		return condition, nil
+	}
+	if !p.keyword("(") {
+		return nil, p.errorf("expected (")
+	}
+	for {
+		value, err := p.value(field)
+		if err != nil {
+			return nil, err
+		}
+		if nil == value {
+			return nil, p.errorf("null can't be in a list")
+		}
+		if condition.Values = append(condition.Values, value); len(condition.Values) > maxFilterValues {
+			return nil, p.errorf("more than %d values", maxFilterValues)
+		}
+		if p.keyword(")") {
+			return condition, nil
+		}
+		if !p.keyword(",") {
+			return nil, p.errorf("expected , or )")
+		}
+	}
+}
+
+func (p *filterParser) value(field *filterField) (any, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if !token.quoted && (token.text == "(" || token.text == ")" || token.text == ",") {
+		p.pos--
+		return nil, p.errorf("expected a value")
+	}
+	value, err := field.value(token.text, token.quoted)
+	if err != nil {
+		p.pos--
+		return nil, p.errorf("%s", err.Error())
+	}
+	return value, nil
+}
+
+// countConditions returns the number of conditions of the node and of its groups.
+func countConditions(node FilterNode) int {
+	group, ok := node.(*FilterGroup)
+	if !ok {
+		return 1
+	}
+	count := 0
+	for _, child := range group.Nodes {
+		count += countConditions(child)
+	}
+	return count
+}
+
+// queryFilter parses the filter of the request: the query parameters named after the fields
+// allowing eq, in when repeated, and the filter parameter. The parameters count toward the
+// limits of the expression.
+func queryFilter(ctx *Context, fields map[string]*filterField) (*Filter, error) {
+	root, err := parseFilter(ctx.Query(FilterParam), fields)
+	if err != nil {
+		return nil, err
+	}
+	conditions := countConditions(root)
+	query := ctx.Request.URL.Query()
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		field := fields[name]
+		literals := query[name]
+		if len(literals) == 0 {
+			continue
+		}
+		operator := FilterEq
+		if len(literals) > 1 {
+			operator = FilterIn
+		}
+		if !slices.Contains(field.operators, operator) {
+			continue
+		}
+		if conditions++; conditions > maxFilterConditions {
+			return nil, fmt.Errorf("filter: more than %d conditions", maxFilterConditions)
+		}
+		if len(literals) > maxFilterValues {
+			return nil, fmt.Errorf("filter: more than %d values of %s", maxFilterValues, name)
+		}
+		condition := &FilterCondition{Field: name, Column: field.column, Operator: operator}
+		for _, literal := range literals {
+			value, err := field.value(literal, true)
+			if err != nil {
+				return nil, errors.New("filter: " + err.Error())
+			}
+			condition.Values = append(condition.Values, value)
+		}
+		root.Nodes = append(root.Nodes, condition)
+	}
+	return &Filter{Root: root}, nil
+}
+
+// filtered parses the filter of the routes whose args have filterable fields,
+// it reports whether the request was refused.
+func (m *HttpServer) filtered(ctx *Context, fields map[string]*filterField) bool {
+	if nil == fields {
+		return false
+	}
+	filter, err := queryFilter(ctx, fields)
+	if err != nil {
+		ctx.Negotiate(http.StatusBadRequest, &Response{
+			Code:      http.StatusBadRequest,
+			Message:   err.Error(),
+			Timestamp: time.Now().Local().Unix(),
+		})
+		return true
+	}
+	ctx.Set(filterContextKey, filter)
+	return false
+}
+
+// Filter returns the filter of the request, an empty filter on the routes without filterable args.
+func (c *Context) Filter() *Filter {
+	if value, ok := c.Get(filterContextKey); ok {
+		return value.(*Filter)
+	}
+	return &Filter{Root: &FilterGroup{}}
+}
+
+// filterDescription documents the syntax of the filter parameter and the fields of the route.
+func filterDescription(fields map[string]*filterField) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var description strings.Builder
+	description.WriteString("Conditions joined by and, or and parentheses, such as `amount ge 100 and (status eq 'paid' or status in ('refunded', 'void'))`. " +
+		"Strings are quoted with ', null compares to NULL with eq and ne. The fields and their operators are:")
+	for _, name := range names {
+		operators := make([]string, 0, len(fields[name].operators))
+		for _, operator := range fields[name].operators {
+			operators = append(operators, string(operator))
+		}
+		description.WriteString(" " + name + " (" + strings.Join(operators, ", ") + ");")
+	}
+	return strings.TrimSuffix(description.String(), ";")
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+type orderArgs struct {
+	Status  string    `query:"status" filter:"eq,in"`
+	Amount  int64     `query:"amount" db:"o.total_amount" filter:"*"`
+	Name    *string   `query:"name" filter:"eq,contains"`
+	Created time.Time `query:"created" filter:"ge,lt"`
+	Secret  string    `query:"secret"`
+}
+
+func TestParseFilter(t *testing.T) {
+	filter, err := ParseFilter(`amount ge 100 and (status in ('paid', 'void') or name contains '50%_off') and created lt 2024-05-01 and name eq null`, &orderArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, args := filter.Where()
+	expected := "`o`.`total_amount` >= ? AND (`status` IN (?, ?) OR `name` LIKE ?) AND `created` < ? AND `name` IS NULL"
+	if where != expected {
+		t.Fatalf("unexpected where %s", where)
+	}
+	if !reflect.DeepEqual(args, []any{int64(100), "paid", "void", `%50\%\_off%`, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}) {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if conditions := filter.Conditions("status"); len(conditions) != 1 || conditions[0].Operator != FilterIn {
+		t.Fatalf("unexpected conditions %v", conditions)
+	}
+
+	filter, _ = ParseFilter(`status eq 'it''s' or amount lt 5 and amount gt 1`, &orderArgs{})
+	if where, _ := filter.Where(); where != "(`status` = ? OR (`o`.`total_amount` < ? AND `o`.`total_amount` > ?))" {
+		t.Fatalf("expected and to bind tighter, got %s", where)
+	}
+
+	for _, expression := range []string{
+		"secret eq 'x'", "status contains 'p'", "amount eq ten", "amount gt null",
+		"status eq 'paid' and", "(status eq 'paid'", "status in ('paid'", "status eq 'paid", "status like 'p'",
+	} {
+		if _, err := ParseFilter(expression, &orderArgs{}); err == nil {
+			t.Fatalf("expected %q to be refused", expression)
+		}
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger()}
+	fields := filterFields(&orderArgs{})
+	engine := gin.New()
+	var where string
+	var args []any
+	engine.GET("/orders", func(c *gin.Context) {
+		ctx := NewContext(c)
+		if !srv.filtered(ctx, fields) {
+			where, args = ctx.Filter().Where()
+		}
+	})
+	request := httptest.NewRequest(http.MethodGet, "/orders?status=paid&status=void&amount=10&filter="+
+		strings.ReplaceAll("name contains 'shop'", " ", "%20"), nil)
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	if where != "`name` LIKE ? AND `o`.`total_amount` = ? AND `status` IN (?, ?)" || len(args) != 4 {
+		t.Fatalf("unexpected where %s %v", where, args)
+	}
+
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders?amount=ten", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected the invalid value to be refused, got %d", recorder.Code)
+	}
+
+	// the parameters have the limits of the expression
+	values := url.Values{"status": make([]string, maxFilterValues+1)}
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders?"+values.Encode(), nil))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "values") {
+		t.Fatalf("expected too many values to be refused, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	expression := strings.Repeat("amount gt 1 and ", maxFilterConditions-1) + "amount gt 1"
+	values = url.Values{"status": {"paid"}, FilterParam: {expression}}
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/orders?"+values.Encode(), nil))
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "conditions") {
+		t.Fatalf("expected too many conditions to be refused, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/gin-gonic/gin"
+	"github.com/swaggest/openapi-go/openapi3"
+)
+
+// RouteGroup registers routes under a common prefix, with the middleware,
+// tags, policy and permission of the group applied to each of them.
+type RouteGroup struct {
+	server      *HttpServer
+	prefix      string
+	base        string
+	version     *APIVersion
+	middleware  []gin.HandlerFunc
+	tags        []string
+	description string
+	policy      authorities.AuthorizationPolicy
+	permission  string
+	cors        *CorsPolicy
+	timeout     time.Duration
+}
+
+type GroupOption func(*RouteGroup)
+
+// WithMiddleware appends middleware running before the routes of the group only.
+func WithMiddleware(middleware ...gin.HandlerFunc) GroupOption {
+	return func(g *RouteGroup) {
+		g.middleware = append(g.middleware, middleware...)
+	}
+}
+
+// WithTags sets the default tags of the routes, the prefix is used as tag when none is given.
+func WithTags(tags ...string) GroupOption {
+	return func(g *RouteGroup) {
+		g.tags = append(g.tags, tags...)
+	}
+}
+
+// WithDescription describes the tags of the group in the OpenAPI spec.
+func WithDescription(description string) GroupOption {
+	return func(g *RouteGroup) {
+		g.description = description
+	}
+}
+
+// WithPolicy sets the default authorization policy of the routes.
+func WithPolicy(policy authorities.AuthorizationPolicy) GroupOption {
+	return func(g *RouteGroup) {
+		g.policy = policy
+	}
+}
+
+// WithPermission sets the default permission required by the routes.
+func WithPermission(permission string) GroupOption {
+	return func(g *RouteGroup) {
+		g.permission = permission
+	}
+}
+
+// WithCors sets the default CORS policy of the routes, see Handler.Cors.
+func WithCors(policy *CorsPolicy) GroupOption {
+	return func(g *RouteGroup) {
+		g.cors = policy
+	}
+}
+
+// WithTimeout sets the default execution timeout of the routes, see Handler.Timeout.
+func WithTimeout(timeout time.Duration) GroupOption {
+	return func(g *RouteGroup) {
+		g.timeout = timeout
+	}
+}
+
+// WithSecurityHeaders replaces the security headers of the server for the routes, see Secure.
+func WithSecurityHeaders(headers *SecurityHeaders) GroupOption {
+	return func(g *RouteGroup) {
+		g.middleware = append(g.middleware, Secure(headers))
+	}
+}
+
+// Group creates a route group under the prefix.
+func (m *HttpServer) Group(prefix string, opts ...GroupOption) APIHandler {
+	return m.group(&RouteGroup{server: m}, prefix, opts...)
+}
+
+func (m *HttpServer) group(parent *RouteGroup, prefix string, opts ...GroupOption) *RouteGroup {
+	path, _ := url.JoinPath("/", parent.prefix, prefix)
+	base, _ := url.JoinPath("/", parent.base, prefix)
+	g := &RouteGroup{
+		server:     m,
+		prefix:     path,
+		base:       base,
+		version:    parent.version,
+		middleware: slices.Clone(parent.middleware),
+		policy:     parent.policy,
+		permission: parent.permission,
+		cors:       parent.cors,
+		timeout:    parent.timeout,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if len(g.tags) == 0 {
+		if tag := strings.Trim(prefix, "/"); tag != "" {
+			g.tags = []string{tag}
+		}
+	}
+	for _, tag := range g.tags {
+		m.addTagDoc(g.reflector(m), tag, g.description)
+	}
+	g.tags = append(slices.Clone(parent.tags), g.tags...)
+	return g
+}
+
+// Group creates a nested group, which inherits the middleware, tags, policy, permission, CORS policy and timeout.
+func (g *RouteGroup) Group(prefix string, opts ...GroupOption) APIHandler {
+	return g.server.group(g, prefix, opts...)
+}
+
+// apply returns a copy of the handler with the defaults of the group.
+func (g *RouteGroup) apply(handler *Handler) *Handler {
+	h := *handler
+	tags := slices.Clone(g.tags)
+	for _, tag := range handler.Tags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	h.Tags = tags
+	if h.Policy == "" {
+		h.Policy = g.policy
+	}
+	if h.Permission == "" {
+		h.Permission = g.permission
+	}
+	if nil == h.Cors {
+		h.Cors = g.cors
+	}
+	if h.Timeout == 0 {
+		h.Timeout = g.timeout
+	}
+	return &h
+}
+
+// handlers returns a copy of the middleware chain, g may be nil for routes outside of groups.
+func (g *RouteGroup) handlers() []gin.HandlerFunc {
+	if nil == g {
+		return nil
+	}
+	return slices.Clone(g.middleware)
+}
+
+// reflector returns the OpenAPI reflector of the version of the group, or the one of the server.
+func (g *RouteGroup) reflector(m *HttpServer) *openapi3.Reflector {
+	if nil == g || nil == g.version {
+		return m.reflector
+	}
+	return g.version.reflector
+}
+
+func (g *RouteGroup) Handle(method string, path string, handler *Handler) {
+	if nil != g.version {
+		base, _ := url.JoinPath(g.base, path)
+		g.version.addRoute(method, base)
+		if nil != handler.Cors || nil != g.cors {
+			// the preflight requests of the default version are rewritten too
+			g.version.addRoute(http.MethodOptions, base)
+		}
+	}
+	path, _ = url.JoinPath(g.prefix, path)
+	g.server.handle(method, path, g.apply(handler), g)
+}
+
+func (g *RouteGroup) Internal(method string, path string, handler *Handler) {
+	path, _ = url.JoinPath(g.prefix, path)
+	g.server.internal(method, path, g.apply(handler), g)
+}
+
+func (g *RouteGroup) Get(path string, handler *Handler) {
+	g.Handle(http.MethodGet, path, handler)
+}
+
+func (g *RouteGroup) Post(path string, handler *Handler) {
+	g.Handle(http.MethodPost, path, handler)
+}
+
+func (g *RouteGroup) Put(path string, handler *Handler) {
+	g.Handle(http.MethodPut, path, handler)
+}
+
+func (g *RouteGroup) Delete(path string, handler *Handler) {
+	g.Handle(http.MethodDelete, path, handler)
+}
+
+func (g *RouteGroup) Patch(path string, handler *Handler) {
+	g.Handle(http.MethodPatch, path, handler)
+}
+
+func (g *RouteGroup) Head(path string, handler *Handler) {
+	g.Handle(http.MethodHead, path, handler)
+}
+
+func (g *RouteGroup) Options(path string, handler *Handler) {
+	g.Handle(http.MethodOptions, path, handler)
+}
+
+// addTagDoc declares the tag in the OpenAPI spec, the first description given is kept.
+func (m *HttpServer) addTagDoc(reflector *openapi3.Reflector, name string, description string) {
+	for i, tag := range reflector.Spec.Tags {
+		if tag.Name == name {
+			if tag.Description == nil && description != "" {
+				reflector.Spec.Tags[i].WithDescription(description)
+			}
+			return
+		}
+	}
+	tag := openapi3.Tag{Name: name}
+	if description != "" {
+		tag.WithDescription(description)
+	}
+	reflector.Spec.Tags = append(reflector.Spec.Tags, tag)
+}
+
+var (
+	_ APIHandler = (*HttpServer)(nil)
+	_ APIHandler = (*RouteGroup)(nil)
+)
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGroup(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyAllow}, tokens)
+	chain := func(name string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Writer.Header().Add("X-Chain", name)
+			c.Next()
+		}
+	}
+	handler := func(ctx *Context) error {
+		ctx.Writer.Header().Add("X-Chain", "handler")
+		ctx.WriteData(ctx.FullPath())
+		return nil
+	}
+	admin := srv.Group("/admin", WithMiddleware(chain("admin")), WithTags("Admin"), WithDescription("Administration"),
+		WithPolicy(authorities.AuthorizationPolicyDeny), WithPermission("admin"))
+	users := admin.Group("/users", WithMiddleware(chain("users")))
+	users.Get("/:id", &Handler{Func: handler})
+	users.Get("/count", &Handler{Policy: authorities.AuthorizationPolicyAllow, Tags: []string{"Stats"}, Func: handler})
+	users.Get("/audit", &Handler{Permission: "audit", Func: handler})
+	admin.Group("/reports", WithMiddleware(chain("reports")), WithPermission("reports")).Get("/summary", &Handler{Func: handler})
+	srv.Get("/public", &Handler{Func: handler})
+
+	code := func(recorder *httptest.ResponseRecorder) int {
+		var response Response
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("unexpected body %s", recorder.Body.String())
+		}
+		return response.Code
+	}
+	admins, _ := tokens.GenerateToken(authorities.NewAuthorized("1", "alice", nil, []string{"admin"}))
+	auditors, _ := tokens.GenerateToken(authorities.NewAuthorized("2", "bob", nil, []string{"admin", "audit"}))
+	reporters, _ := tokens.GenerateToken(authorities.NewAuthorized("3", "carol", nil, []string{"reports"}))
+
+	for _, test := range []struct {
+		target string
+		token  string
+		code   int
+		chain  []string
+	}{
+		{"/admin/users/1", "", 401, []string{"admin", "users"}},
+		{"/admin/users/1", reporters, 403, []string{"admin", "users"}},
+		{"/admin/users/1", admins, 0, []string{"admin", "users", "handler"}},
+		{"/admin/users/count", "", 0, []string{"admin", "users", "handler"}},
+		{"/admin/users/audit", admins, 403, []string{"admin", "users"}},
+		{"/admin/users/audit", auditors, 0, []string{"admin", "users", "handler"}},
+		{"/admin/reports/summary", admins, 403, []string{"admin", "reports"}},
+		{"/admin/reports/summary", reporters, 0, []string{"admin", "reports", "handler"}},
+		{"/public", "", 0, []string{"handler"}},
+	} {
+		var header []string
+		if test.token != "" {
+			header = []string{AuthorizationKey, test.token}
+		}
+		recorder := serve(srv, http.MethodGet, test.target, header...)
+		if code(recorder) != test.code || !slices.Equal(recorder.Header().Values("X-Chain"), test.chain) {
+			t.Fatalf("unexpected response of %s with %q: %s %v", test.target, test.token, recorder.Body.String(), recorder.Header().Values("X-Chain"))
+		}
+	}
+
+	paths := srv.reflector.Spec.Paths.MapOfPathItemValues
+	for path, tags := range map[string][]string{
+		"/admin/users/{id}":      {"Admin", "users"},
+		"/admin/users/count":     {"Admin", "users", "Stats"},
+		"/admin/reports/summary": {"Admin", "reports"},
+	} {
+		operation := paths[path].MapOfOperationValues["get"]
+		if !slices.Equal(operation.Tags, tags) {
+			t.Fatalf("expected the tags %v on %s, got %v", tags, path, operation.Tags)
+		}
+	}
+	var described bool
+	for _, tag := range srv.reflector.Spec.Tags {
+		described = described || (tag.Name == "Admin" && nil != tag.Description && *tag.Description == "Administration")
+	}
+	if !described || len(paths["/public"].MapOfOperationValues["get"].Tags) != 0 {
+		t.Fatalf("unexpected tags %+v", srv.reflector.Spec.Tags)
+	}
+}
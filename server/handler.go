@@ -1,10 +1,15 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
+	"github.com/deepissue/core/authorities"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,6 +19,32 @@ type Handler struct {
 	Func  func(*Context) error
 	Args  any
 	Reply any
+	// RateLimit limits the route in addition to the global limit, see HttpServer.SetRateLimiter
+	RateLimit *RateLimit
+	// Policy overrides the default policy of the authorization settings for the route
+	Policy authorities.AuthorizationPolicy
+	// Permission is required from the authorized account when set
+	Permission string
+	// Idempotency enables the Idempotency-Key header for the unsafe methods
+	Idempotency *Idempotency
+	// Cors overrides the CORS policy of the server for the route, including its preflight requests
+	Cors *CorsPolicy
+	// Paging validates the pagination parameters of the route, see Context.PageRequest
+	Paging *Paging
+	// Upload limits the multipart files of the route, see Context.Uploads
+	Upload *Upload
+	// Cache caches the responses of the GET route, see Cache
+	Cache *Cache
+	// CSRFExempt skips the CSRF check of the route, for the requests which can't carry a token such as the CSP reports
+	CSRFExempt bool
+	// Stream marks a route serving server-sent events, its token may be sent in the access_token query
+	// as EventSource can't set headers, see Context.Stream
+	Stream bool
+	// Timeout bounds the execution of the handler, the one of the server when 0, none when negative.
+	// The deadline is set on the request context, a 503 Response is sent once it passed.
+	// The timeout of the server doesn't apply to the Stream and Upload routes, the routes serving
+	// files with HttpServer.ServeFile opt out with a negative timeout.
+	Timeout time.Duration
 }
 
 type APIHandler interface {
@@ -21,52 +52,144 @@ type APIHandler interface {
 	Post(path string, handler *Handler)
 	Put(path string, handler *Handler)
 	Delete(path string, handler *Handler)
+	Patch(path string, handler *Handler)
 	Head(path string, handler *Handler)
 	Options(path string, handler *Handler)
 
 	Handle(method string, path string, handler *Handler)
 
 	Internal(method string, path string, handler *Handler)
+
+	Group(prefix string, opts ...GroupOption) APIHandler
 }
 
 // Handle registers a new route with the HTTP server.
 func (m *HttpServer) Handle(method string, path string, handler *Handler) {
+	m.handle(method, path, handler, nil)
+}
+
+func (m *HttpServer) Internal(method string, path string, handler *Handler) {
+	m.internal(method, path, handler, nil)
+}
+
+// handle registers the route with the middleware of the group, which may be nil.
+func (m *HttpServer) handle(method string, path string, handler *Handler, group *RouteGroup) {
 
 	path, _ = url.JoinPath(m.path, path)
+	if nil != handler.RateLimit {
+		if err := handler.RateLimit.validate(); err != nil {
+			m.invalidRoute(method, path, err)
+			return
+		}
+	}
+	if nil != handler.Cors {
+		if err := m.corsRoute(method, path, handler.Cors); err != nil {
+			m.invalidRoute(method, path, err)
+			return
+		}
+	}
+	filters := filterFields(handler.Args)
 
-	m.engine.Handle(method, path, func(c *gin.Context) {
+	handlers := append(group.handlers(), func(c *gin.Context) {
 		ctx := NewContext(c)
-		if err := m.Authorization(ctx); err != nil {
+		ctx.writeTimeout = m.httpServer.WriteTimeout
+		// the stream is closed by handled, or here when the handler panics
+		defer ctx.closeStream()
+		if handler.Stream {
+			ctx.Set(queryTokenContextKey, true)
+		}
+		if m.rateLimited(ctx, handler, false) {
+			return
+		}
+		if err := m.authorize(ctx, handler); err != nil {
 			ctx.WriteFail(401, err.Error())
 			return
 		}
-		err := handler.Func(ctx)
-		if nil != err {
-			ctx.Status(400)
-			ctx.Writer.WriteString(err.Error())
+		if !m.permitted(ctx, handler) {
+			ctx.WriteFail(403, "permission denied")
+			return
+		}
+		if m.rateLimited(ctx, handler, true) {
+			return
+		}
+		if m.csrfProtected(ctx, handler) {
+			return
+		}
+		if m.paginated(ctx, handler) {
+			return
+		}
+		if m.filtered(ctx, filters) {
+			return
 		}
+		m.limitUpload(ctx, handler)
+		m.timed(ctx, handler, func() {
+			m.cached(ctx, handler, func() {
+				m.idempotent(ctx, handler, func() {
+					m.handled(ctx, handler.Func(ctx))
+				})
+			})
+		})
 	})
+	m.engine.Handle(method, path, handlers...)
+	if nil != handler.Paging {
+		m.pagination.register(handler.Paging, m.logger)
+	}
 	path = strings.TrimPrefix(path, "/")
-	m.addHandlerDoc(method, "/"+path, handler)
+	m.addHandlerDoc(group.reflector(m), method, "/"+path, handler, false)
 }
 
-func (m *HttpServer) Internal(method string, path string, handler *Handler) {
+// internal registers a route under /internal requiring the internal secret, refused when none is configured.
+func (m *HttpServer) internal(method string, path string, handler *Handler, group *RouteGroup) {
 	path, _ = url.JoinPath(m.path, "internal", path)
 
-	m.engine.Handle(method, path, func(c *gin.Context) {
+	handlers := append(group.handlers(), func(c *gin.Context) {
 		ctx := NewContext(c)
-		if c.GetHeader(InternalSecretKey) != m.authorization.Settings().InternalSecret {
+		ctx.writeTimeout = m.httpServer.WriteTimeout
+		// the stream is closed by handled, or here when the handler panics
+		defer ctx.closeStream()
+		if !m.internalSecret(c.Request) {
 			ctx.WriteFail(401, "Internal secret key required")
 			return
 		}
-		err := handler.Func(ctx)
-		if nil != err {
-			ctx.Status(400)
-			ctx.Writer.WriteString(err.Error())
-		}
+		m.timed(ctx, handler, func() {
+			m.handled(ctx, handler.Func(ctx))
+		})
 	})
+	m.engine.Handle(method, path, handlers...)
 	path = strings.TrimPrefix(path, "/")
-	m.addHandlerDoc(method, "/"+path, handler)
+	m.addHandlerDoc(m.internalReflector, method, "/"+path, handler, true)
+}
+
+// invalidRoute rejects a route at registration, the route isn't served and Startup fails with the error.
+func (m *HttpServer) invalidRoute(method string, path string, err error) {
+	m.logger.Error("invalid route", "method", method, "path", path, "err", err)
+	m.invalidRoutes = errors.Join(m.invalidRoutes, fmt.Errorf("%s %s: %w", method, path, err))
+}
+
+// handled ends the request once the handler returned, the error is written unless a response was already.
+// The status is 400 unless the error has an HTTPStatus, such as a StatusError, is a body too large, which is
+// a 413, or is a deadline exceeded, such as the one of an outbound call, which is a 504.
+func (m *HttpServer) handled(ctx *Context, err error) {
+	ctx.closeStream()
+	if nil == err {
+		return
+	}
+	if ctx.Writer.Written() {
+		m.logger.Warn("handler failed after writing the response", "path", ctx.FullPath(), "err", err)
+		return
+	}
+	status := http.StatusBadRequest
+	var statusErr interface{ HTTPStatus() int }
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &statusErr) {
+		status = statusErr.HTTPStatus()
+	} else if errors.As(err, &maxBytes) {
+		status = http.StatusRequestEntityTooLarge
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+	ctx.Status(status)
+	ctx.Writer.WriteString(err.Error())
 }
 
 func (m *HttpServer) Get(path string, handler *Handler) {
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepissue/core/websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultHealthCheckTimeout = 3 * time.Second
+
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+// HealthChecker reports whether a dependency of the application is usable.
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// HealthCheckerFunc adapts an ordinary function to a HealthChecker.
+type HealthCheckerFunc func(ctx context.Context) error
+
+func (f HealthCheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HealthCheck registers a checker under a name.
+// A failing Critical check turns both /healthz and /readyz into 503,
+// a failing non-critical check is only reported.
+type HealthCheck struct {
+	Name     string
+	Checker  HealthChecker
+	Timeout  time.Duration
+	Critical bool
+}
+
+// HealthResult is the result of a check, the endpoints log its Error rather than sending it.
+type HealthResult struct {
+	Status   string        `json:"status"`
+	Critical bool          `json:"critical"`
+	Error    string        `json:"error,omitempty"`
+	Latency  time.Duration `json:"latency"`
+}
+
+type HealthReport struct {
+	Status string                   `json:"status"`
+	Ready  bool                     `json:"ready"`
+	Checks map[string]*HealthResult `json:"checks,omitempty"`
+}
+
+// Health is the registry of health checks shared by the Server and its HttpServers.
+type Health struct {
+	mutex    sync.RWMutex
+	checks   []*HealthCheck
+	shutting atomic.Bool
+}
+
+func NewHealth() *Health {
+	return &Health{}
+}
+
+// Register adds a check, a check with the same name is replaced.
+func (h *Health) Register(check *HealthCheck) {
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, c := range h.checks {
+		if c.Name == check.Name {
+			h.checks[i] = check
+			return
+		}
+	}
+	h.checks = append(h.checks, check)
+}
+
+// Deregister removes the check with the given name.
+func (h *Health) Deregister(name string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, c := range h.checks {
+		if c.Name == name {
+			h.checks = append(h.checks[:i], h.checks[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetShutting marks the application as shutting down, readiness reports false from then on.
+func (h *Health) SetShutting() {
+	h.shutting.Store(true)
+}
+
+func (h *Health) Ready() bool {
+	return !h.shutting.Load()
+}
+
+// Check runs all registered checks concurrently, each one bounded by its own timeout.
+func (h *Health) Check(ctx context.Context) *HealthReport {
+	h.mutex.RLock()
+	checks := make([]*HealthCheck, len(h.checks))
+	copy(checks, h.checks)
+	h.mutex.RUnlock()
+
+	report := &HealthReport{
+		Status: HealthStatusUp,
+		Ready:  h.Ready(),
+		Checks: make(map[string]*HealthResult, len(checks)),
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check *HealthCheck) {
+			defer wg.Done()
+			result := runHealthCheck(ctx, check)
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status == HealthStatusDown && check.Critical {
+				report.Status = HealthStatusDown
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+func runHealthCheck(ctx context.Context, check *HealthCheck) (result *HealthResult) {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	result = &HealthResult{Status: HealthStatusUp, Critical: check.Critical}
+	defer func() {
+		result.Latency = time.Since(start)
+	}()
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic in health check: %v", r)
+			}
+		}()
+		errCh <- check.Checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		result.Status = HealthStatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// RedisChecker pings the redis client, such as the one passed to authorities.NewRedisTokenHandler.
+func RedisChecker(client redis.UniversalClient) HealthChecker {
+	return HealthCheckerFunc(func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+}
+
+// SQLChecker pings the database.
+func SQLChecker(db *sql.DB) HealthChecker {
+	return HealthCheckerFunc(func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	})
+}
+
+// WebsocketChecker reports whether the websocket client is connected.
+func WebsocketChecker(client *websocket.Client) HealthChecker {
+	return HealthCheckerFunc(func(ctx context.Context) error {
+		if !client.IsConnected() {
+			return errors.New("websocket client is not connected")
+		}
+		return nil
+	})
+}
+
+// AddHealthCheck registers a check on the health registry shared with the Server.
+func (m *HttpServer) AddHealthCheck(check *HealthCheck) {
+	m.health.Register(check)
+}
+
+func (m *HttpServer) healthz() {
+	livez, _ := url.JoinPath(m.path, "livez")
+	m.engine.GET(livez, func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusOK, &Response{
+			Code:      0,
+			Content:   &HealthReport{Status: HealthStatusUp, Ready: m.health.Ready()},
+			Timestamp: time.Now().Local().Unix(),
+		})
+	})
+
+	healthz, _ := url.JoinPath(m.path, "healthz")
+	m.engine.GET(healthz, func(c *gin.Context) {
+		report := m.health.Check(c.Request.Context())
+		m.writeHealthReport(c, report, report.Status == HealthStatusUp)
+	})
+
+	readyz, _ := url.JoinPath(m.path, "readyz")
+	m.engine.GET(readyz, func(c *gin.Context) {
+		if !m.health.Ready() {
+			m.writeHealthReport(c, &HealthReport{Status: HealthStatusDown, Ready: false}, false)
+			return
+		}
+		report := m.health.Check(c.Request.Context())
+		m.writeHealthReport(c, report, report.Status == HealthStatusUp && report.Ready)
+	})
+}
+
+// writeHealthReport sends the status of the checks, the probes are public so the errors,
+// which may describe the infrastructure, are logged instead.
+func (m *HttpServer) writeHealthReport(c *gin.Context, report *HealthReport, ok bool) {
+	for name, result := range report.Checks {
+		if result.Error != "" {
+			m.logger.Warn("health check failed", "name", name, "critical", result.Critical, "err", result.Error)
+			result.Error = ""
+		}
+	}
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	res := &Response{
+		Content:   report,
+		Timestamp: time.Now().Local().Unix(),
+	}
+	if !ok {
+		res.Code = status
+		res.Message = "service unavailable"
+	}
+	c.AbortWithStatusJSON(status, res)
+}
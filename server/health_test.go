@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+)
+
+func TestHealthCheck(t *testing.T) {
+	health := NewHealth()
+	health.Register(&HealthCheck{Name: "ok", Checker: HealthCheckerFunc(func(ctx context.Context) error {
+		return nil
+	}), Critical: true})
+	health.Register(&HealthCheck{Name: "optional", Checker: HealthCheckerFunc(func(ctx context.Context) error {
+		return errors.New("unavailable")
+	})})
+
+	report := health.Check(context.Background())
+	if report.Status != HealthStatusUp {
+		t.Fatalf("non-critical failure must not fail the report, got %s", report.Status)
+	}
+
+	health.Register(&HealthCheck{Name: "slow", Checker: HealthCheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), Timeout: 10 * time.Millisecond, Critical: true})
+
+	report = health.Check(context.Background())
+	if report.Status != HealthStatusDown {
+		t.Fatalf("critical timeout must fail the report, got %s", report.Status)
+	}
+	if !report.Ready {
+		t.Fatal("expected ready before shutdown")
+	}
+
+	health.SetShutting()
+	if health.Ready() {
+		t.Fatal("expected not ready after shutdown started")
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := newTestCore(t)
+	srv := newTestHttpServer(t, s, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, nil)
+	var failing atomic.Bool
+	srv.AddHealthCheck(&HealthCheck{Name: "database", Critical: true, Checker: HealthCheckerFunc(func(ctx context.Context) error {
+		if failing.Load() {
+			return errors.New("dial tcp 10.0.0.12:5432: connection refused")
+		}
+		return nil
+	})})
+	report := func(path string, status int) *HealthReport {
+		t.Helper()
+		recorder := serve(srv, http.MethodGet, path)
+		var response struct {
+			Content HealthReport `json:"content"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != status {
+			t.Fatalf("expected %d from %s, got %d %s", status, path, recorder.Code, recorder.Body.String())
+		}
+		if strings.Contains(recorder.Body.String(), "10.0.0.12") {
+			t.Fatalf("expected the error of the check not to be sent: %s", recorder.Body.String())
+		}
+		return &response.Content
+	}
+
+	if health := report("/healthz", http.StatusOK); health.Checks["database"].Status != HealthStatusUp {
+		t.Fatalf("unexpected report %+v", health)
+	}
+	report("/readyz", http.StatusOK)
+
+	failing.Store(true)
+	if health := report("/healthz", http.StatusServiceUnavailable); health.Checks["database"].Status != HealthStatusDown {
+		t.Fatalf("unexpected report %+v", health)
+	}
+	report("/readyz", http.StatusServiceUnavailable)
+
+	failing.Store(false)
+	s.health.SetShutting()
+	if ready := report("/readyz", http.StatusServiceUnavailable); ready.Ready {
+		t.Fatal("expected not ready once the shutdown started")
+	}
+	report("/healthz", http.StatusOK)
+	report("/livez", http.StatusOK)
+}
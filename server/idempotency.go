@@ -0,0 +1,332 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	IdempotencyKey = "Idempotency-Key"
+	// IdempotentReplayedKey marks the responses replayed from the store
+	IdempotentReplayedKey = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL  = 24 * time.Hour
+	defaultIdempotencyBody = 1 << 20
+)
+
+// Idempotency enables the Idempotency-Key header on a route, requests of unsafe methods sent again
+// with the same key by the same account get the stored response instead of being handled twice.
+type Idempotency struct {
+	// TTL is how long the responses are kept, a day by default
+	TTL time.Duration
+	// Required rejects the requests without a key
+	Required bool
+	// MaxBody bounds the body read to fingerprint the request, 1MB by default, larger requests are refused with 413
+	MaxBody int64
+}
+
+func (i *Idempotency) ttl() time.Duration {
+	if i.TTL > 0 {
+		return i.TTL
+	}
+	return defaultIdempotencyTTL
+}
+
+func (i *Idempotency) maxBody() int64 {
+	if i.MaxBody > 0 {
+		return i.MaxBody
+	}
+	return defaultIdempotencyBody
+}
+
+// IdempotencyRecord is the request fingerprint of a key and, once Done, its response.
+type IdempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps the records of the idempotency keys.
+type IdempotencyStore interface {
+	// Reserve stores an in-flight record for the key, or returns the record already stored.
+	Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error)
+	// Complete stores the response of the key.
+	Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release drops the key, so that the request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+type memoryIdempotencyRecord struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+type memoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	records map[string]*memoryIdempotencyRecord
+	swept   time.Time
+}
+
+// NewMemoryIdempotencyStore keeps the records in the process, for single instance deployments.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]*memoryIdempotencyRecord), swept: time.Now()}
+}
+
+func (m *memoryIdempotencyStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	m.sweep(now)
+	if stored, ok := m.records[key]; ok && now.Before(stored.expires) {
+		return stored.record, nil
+	}
+	m.records[key] = &memoryIdempotencyRecord{
+		record:  &IdempotencyRecord{Fingerprint: fingerprint},
+		expires: now.Add(ttl),
+	}
+	return nil, nil
+}
+
+func (m *memoryIdempotencyStore) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records[key] = &memoryIdempotencyRecord{record: record, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+// sweep drops the expired records, once a minute.
+func (m *memoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, stored := range m.records {
+		if now.After(stored.expires) {
+			delete(m.records, key)
+		}
+	}
+}
+
+type redisIdempotencyStore struct {
+	redis  redis.UniversalClient
+	prefix string
+}
+
+// NewRedisIdempotencyStore shares the records between instances through redis.
+func NewRedisIdempotencyStore(client redis.UniversalClient, prefix string) IdempotencyStore {
+	if prefix == "" {
+		prefix = "idempotency:"
+	}
+	return &redisIdempotencyStore{redis: client, prefix: prefix}
+}
+
+func (r *redisIdempotencyStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	value, err := json.Marshal(&IdempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := r.redis.SetNX(ctx, r.prefix+key, value, ttl).Result()
+	if err != nil || reserved {
+		return nil, err
+	}
+	stored, err := r.redis.Get(ctx, r.prefix+key).Bytes()
+	if err == redis.Nil {
+		// expired in between, reserve again
+		return r.Reserve(ctx, key, fingerprint, ttl)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record IdempotencyRecord
+	if err := json.Unmarshal(stored, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (r *redisIdempotencyStore) Complete(ctx context.Context, key string, record *IdempotencyRecord, ttl time.Duration) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return r.redis.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+func (r *redisIdempotencyStore) Release(ctx context.Context, key string) error {
+	return r.redis.Del(ctx, r.prefix+key).Err()
+}
+
+// SetIdempotencyStore sets the storage of the idempotency keys, the records are kept in memory by default.
+func (m *HttpServer) SetIdempotencyStore(store IdempotencyStore) {
+	m.idempotency = store
+}
+
+// idempotent runs the handler once per idempotency key, the retries get the stored response.
+// Responses of server errors aren't stored, so that the request can be retried.
+func (m *HttpServer) idempotent(ctx *Context, handler *Handler, serve func()) {
+	method := ctx.Request.Method
+	if nil == handler.Idempotency || method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		serve()
+		return
+	}
+	key := ctx.GetHeader(IdempotencyKey)
+	if key == "" {
+		if handler.Idempotency.Required {
+			ctx.Negotiate(http.StatusBadRequest, idempotencyFail(http.StatusBadRequest, "idempotency key required"))
+			return
+		}
+		serve()
+		return
+	}
+
+	subject := "ip:" + m.ClientIP(ctx)
+	if nil != ctx.Authorized && ctx.Authorized.ID != "" {
+		subject = "account:" + ctx.Authorized.ID.String()
+	} else if ctx.ClientID != "" {
+		subject = "client:" + ctx.ClientID
+	}
+	key = method + " " + ctx.FullPath() + ":" + subject + ":" + key
+
+	fingerprint, err := requestFingerprint(ctx, handler.Idempotency.maxBody())
+	if err != nil {
+		status := http.StatusBadRequest
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		ctx.Negotiate(status, idempotencyFail(status, err.Error()))
+		return
+	}
+	ttl := handler.Idempotency.ttl()
+	stored, err := m.idempotency.Reserve(ctx.Request.Context(), key, fingerprint, ttl)
+	if err != nil {
+		m.logger.Warn("idempotency store unavailable, request handled", "key", key, "err", err)
+		serve()
+		return
+	}
+	if nil != stored {
+		switch {
+		case stored.Fingerprint != fingerprint:
+			ctx.Negotiate(http.StatusConflict, idempotencyFail(http.StatusConflict, "idempotency key reused with a different request"))
+		case !stored.Done:
+			ctx.Negotiate(http.StatusConflict, idempotencyFail(http.StatusConflict, "a request with the same idempotency key is in progress"))
+		default:
+			replayResponse(ctx, stored)
+		}
+		return
+	}
+
+	recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+	ctx.Writer = recorder
+	completed := false
+	defer func() {
+		ctx.Writer = recorder.ResponseWriter
+		if !completed {
+			// the handler panicked
+			m.idempotency.Release(context.WithoutCancel(ctx.Request.Context()), key)
+		}
+	}()
+	serve()
+	completed = true
+
+	background := context.WithoutCancel(ctx.Request.Context())
+	if status := recorder.Status(); status >= http.StatusInternalServerError {
+		err = m.idempotency.Release(background, key)
+	} else {
+		err = m.idempotency.Complete(background, key, &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Done:        true,
+			Status:      status,
+			Header:      storedHeader(recorder.Header()),
+			Body:        recorder.body.Bytes(),
+		}, ttl)
+	}
+	if err != nil {
+		m.logger.Warn("idempotency store unavailable, response not stored", "key", key, "err", err)
+	}
+}
+
+// requestFingerprint hashes the method, the url and the body of at most limit bytes,
+// the body is restored for the handler.
+func requestFingerprint(ctx *Context, limit int64) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.RequestURI() + "\n"))
+	if nil != ctx.Request.Body {
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit))
+		if err != nil {
+			return "", err
+		}
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func idempotencyFail(code int, message string) *Response {
+	return &Response{Code: code, Message: message, Timestamp: time.Now().Local().Unix()}
+}
+
+func replayResponse(ctx *Context, record *IdempotencyRecord) {
+	header := ctx.Writer.Header()
+	for name, values := range record.Header {
+		header[name] = values
+	}
+	header.Set(IdempotentReplayedKey, "true")
+	ctx.Abort()
+	ctx.Writer.WriteHeader(record.Status)
+	ctx.Writer.Write(record.Body)
+}
+
+// storedHeader drops the headers describing the transfer rather than the response.
+func storedHeader(header http.Header) http.Header {
+	stored := header.Clone()
+	for name := range stored {
+		switch {
+		case name == "Content-Encoding", name == "Content-Length", name == "Date", name == "Vary",
+			name == "Retry-After", strings.HasPrefix(name, "Ratelimit-"):
+			stored.Del(name)
+		}
+	}
+	return stored
+}
+
+// responseRecorder keeps a copy of the body written to the client.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
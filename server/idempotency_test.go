@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestIdempotency(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger(), idempotency: NewMemoryIdempotencyStore()}
+	handler := &Handler{Idempotency: &Idempotency{}}
+	calls := 0
+	engine := gin.New()
+	engine.POST("/pay", func(c *gin.Context) {
+		ctx := NewContext(c)
+		srv.idempotent(ctx, handler, func() {
+			calls++
+			ctx.WriteData(fmt.Sprintf("payment %d", calls))
+		})
+	})
+
+	pay := func(key string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/pay", strings.NewReader(body))
+		request.Header.Set(IdempotencyKey, key)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := pay("a", `{"amount":1}`)
+	retry := pay("a", `{"amount":1}`)
+	if calls != 1 || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the retry to be replayed, handled %d times", calls)
+	}
+	if retry.Header().Get(IdempotentReplayedKey) != "true" {
+		t.Fatal("expected the replayed header")
+	}
+	if recorder := pay("a", `{"amount":2}`); recorder.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a different payload, got %d", recorder.Code)
+	}
+
+	srv.idempotency.Reserve(context.Background(), "POST /pay:ip:192.0.2.1:b", "in flight", time.Minute)
+	if recorder := pay("b", `{"amount":1}`); recorder.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a request in progress, got %d", recorder.Code)
+	}
+	if pay("c", `{"amount":1}`); calls != 2 {
+		t.Fatalf("expected a new key to be handled, handled %d times", calls)
+	}
+
+	handler.Idempotency.MaxBody = 16
+	if recorder := pay("d", `{"amount":1,"note":"too long"}`); recorder.Code != http.StatusRequestEntityTooLarge || calls != 2 {
+		t.Fatalf("expected the body over the limit to be refused, got %d handled %d times", recorder.Code, calls)
+	}
+}
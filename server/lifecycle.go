@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Component is a subsystem whose lifecycle is managed by the Server.
+type Component interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+type componentFuncs struct {
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+func (c *componentFuncs) Start(ctx context.Context) error {
+	if c.start == nil {
+		return nil
+	}
+	return c.start(ctx)
+}
+
+func (c *componentFuncs) Stop(ctx context.Context) error {
+	if c.stop == nil {
+		return nil
+	}
+	return c.stop(ctx)
+}
+
+// NewComponent adapts a pair of functions to a Component, either of them may be nil.
+func NewComponent(start, stop func(ctx context.Context) error) Component {
+	return &componentFuncs{start: start, stop: stop}
+}
+
+type component struct {
+	name         string
+	component    Component
+	dependencies []string
+}
+
+// Lifecycle starts components in dependency order and stops them in reverse.
+type Lifecycle struct {
+	mutex      sync.Mutex
+	logger     hclog.Logger
+	components []*component
+	started    []*component
+}
+
+func NewLifecycle(logger hclog.Logger) *Lifecycle {
+	return &Lifecycle{logger: logger}
+}
+
+// Register adds a component that is started after all of its dependencies.
+func (l *Lifecycle) Register(name string, c Component, dependencies ...string) error {
+	if nil == c {
+		return errors.New("component can not be nil")
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, registered := range l.components {
+		if registered.name == name {
+			return fmt.Errorf("component %s already registered", name)
+		}
+	}
+	l.components = append(l.components, &component{name: name, component: c, dependencies: dependencies})
+	return nil
+}
+
+// Start starts all components in topological order. When a component fails,
+// the components already started are stopped in reverse order.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	ordered, err := l.sort()
+	if err != nil {
+		return err
+	}
+
+	for _, c := range ordered {
+		l.logger.Info("starting component", "name", c.name)
+		if err := c.component.Start(ctx); err != nil {
+			l.logger.Error("start component", "name", c.name, "err", err)
+			l.stop(ctx)
+			return fmt.Errorf("start component %s: %w", c.name, err)
+		}
+		l.started = append(l.started, c)
+	}
+	return nil
+}
+
+// Stop stops the started components in reverse order of starting.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.stop(ctx)
+}
+
+func (l *Lifecycle) stop(ctx context.Context) error {
+	var errs []error
+	for i := len(l.started) - 1; i >= 0; i-- {
+		c := l.started[i]
+		l.logger.Info("stopping component", "name", c.name)
+		if err := c.component.Stop(ctx); err != nil {
+			l.logger.Error("stop component", "name", c.name, "err", err)
+			errs = append(errs, fmt.Errorf("stop component %s: %w", c.name, err))
+		}
+	}
+	l.started = nil
+	return errors.Join(errs...)
+}
+
+// sort orders the components so that dependencies come first,
+// ties keep the order of registration.
+func (l *Lifecycle) sort() ([]*component, error) {
+	indexes := make(map[string]int, len(l.components))
+	for i, c := range l.components {
+		indexes[c.name] = i
+	}
+
+	degrees := make([]int, len(l.components))
+	dependents := make([][]int, len(l.components))
+	for i, c := range l.components {
+		for _, dependency := range c.dependencies {
+			j, ok := indexes[dependency]
+			if !ok {
+				return nil, fmt.Errorf("component %s depends on unknown component %s", c.name, dependency)
+			}
+			degrees[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i, degree := range degrees {
+		if degree == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	ordered := make([]*component, 0, len(l.components))
+	for len(ready) > 0 {
+		slices.Sort(ready)
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, l.components[i])
+		for _, j := range dependents[i] {
+			degrees[j]--
+			if degrees[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	if len(ordered) != len(l.components) {
+		var cyclic []string
+		for i, degree := range degrees {
+			if degree > 0 {
+				cyclic = append(cyclic, l.components[i].name)
+			}
+		}
+		return nil, fmt.Errorf("cyclic component dependencies: %v", cyclic)
+	}
+	return ordered, nil
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestLifecycle(t *testing.T) {
+	var events []string
+	record := func(name string, fail bool) Component {
+		return NewComponent(func(ctx context.Context) error {
+			if fail {
+				return errors.New("failed")
+			}
+			events = append(events, "start "+name)
+			return nil
+		}, func(ctx context.Context) error {
+			events = append(events, "stop "+name)
+			return nil
+		})
+	}
+
+	lifecycle := NewLifecycle(hclog.NewNullLogger())
+	lifecycle.Register("http", record("http", false), "redis", "mysql")
+	lifecycle.Register("redis", record("redis", false))
+	lifecycle.Register("mysql", record("mysql", false), "redis")
+
+	if err := lifecycle.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := lifecycle.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"start redis", "start mysql", "start http", "stop http", "stop mysql", "stop redis"}
+	if !slices.Equal(events, expected) {
+		t.Fatalf("unexpected order: %v", events)
+	}
+
+	events = nil
+	lifecycle = NewLifecycle(hclog.NewNullLogger())
+	lifecycle.Register("redis", record("redis", false))
+	lifecycle.Register("http", record("http", true), "redis")
+	if err := lifecycle.Start(context.Background()); err == nil {
+		t.Fatal("expected start failure")
+	}
+	if !slices.Equal(events, []string{"start redis", "stop redis"}) {
+		t.Fatalf("expected rollback, got %v", events)
+	}
+
+	lifecycle = NewLifecycle(hclog.NewNullLogger())
+	lifecycle.Register("a", record("a", false), "b")
+	lifecycle.Register("b", record("b", false), "a")
+	if err := lifecycle.Start(context.Background()); err == nil {
+		t.Fatal("expected cyclic dependency error")
+	}
+}
+
+func TestShutdownOrder(t *testing.T) {
+	s := newTestCore(t, "--http.address", "127.0.0.1", "--http.port", "0")
+	var events []string
+	// the callbacks of http.Server.Shutdown run in their own goroutines
+	drains := make(chan string, 4)
+	standalone := newTestHttpServer(t, s, &authorities.Settings{}, nil)
+	managed := newTestHttpServer(t, s, &authorities.Settings{}, nil)
+	standalone.httpServer.RegisterOnShutdown(func() { drains <- "drain standalone" })
+	managed.httpServer.RegisterOnShutdown(func() { drains <- "drain managed" })
+	if err := s.Register("http", managed.Component(), "redis"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("redis", NewComponent(func(ctx context.Context) error { return nil }, func(ctx context.Context) error {
+		// both servers are drained before the components they depend on stop
+		var drained []string
+		for len(drained) < 2 {
+			select {
+			case drain := <-drains:
+				drained = append(drained, drain)
+			case <-time.After(time.Second):
+				t.Errorf("expected the servers to be drained, got %v", drained)
+				return nil
+			}
+		}
+		slices.Sort(drained)
+		events = append(events, drained...)
+		events = append(events, "stop redis")
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	s.OnShutdown("flush", func(ctx context.Context) error {
+		events = append(events, "hook")
+		return nil
+	})
+	if err := standalone.Startup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.lifecycle.Start(s.Ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Shutdown(func() { events = append(events, "stop") })
+	expected := []string{"drain managed", "drain standalone", "stop redis", "stop", "hook"}
+	if !slices.Equal(events, expected) {
+		t.Fatalf("unexpected order: %v", events)
+	}
+	// the servers aren't drained again once the context of the server is canceled
+	select {
+	case drain := <-drains:
+		t.Fatalf("unexpected second %s", drain)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,31 +1,314 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/hashicorp/go-hclog"
 )
 
+const RequestIDKey = "X-Request-ID"
+
+const (
+	requestIDContextKey  = "core.request_id"
+	authorizedContextKey = "core.authorized"
+	redacted             = "[REDACTED]"
+)
+
+// sensitiveHeaders are always redacted
+var sensitiveHeaders = []string{AuthorizationKey, InternalSecretKey, "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// HclogMiddleware logs every request at info, see AccessLogger.
 func HclogMiddleware(logger hclog.Logger) gin.HandlerFunc {
+	return AccessLogger(logger, &option.Access{})
+}
+
+// AccessLogger logs the requests with their route, request id, account and sizes,
+// and the bodies up to the configured length. Requests below 400 are sampled,
+// 4xx are logged at warn and 5xx at error. The request id is taken from X-Request-ID or generated.
+func AccessLogger(logger hclog.Logger, opts *option.Access) gin.HandlerFunc {
+	level := hclog.LevelFromString(opts.Success)
+	if level == hclog.NoLevel {
+		level = hclog.Info
+	}
+	sample := opts.Sample
+	if sample <= 0 || sample > 1 {
+		sample = 1
+	}
+	redactor := newRedactor(opts.Redact)
+
 	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDKey)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.NewString()
+		}
+		c.Set(requestIDContextKey, requestID)
+		c.Writer.Header().Set(RequestIDKey, requestID)
+
+		for _, pattern := range opts.Skip {
+			if matched, _ := path.Match(pattern, requestPath(c.Request)); matched {
+				c.Next()
+				return
+			}
+		}
+
 		start := time.Now()
+		body := &bodyReader{ReadCloser: c.Request.Body}
+		if opts.Body > 0 {
+			body.capture = &capture{limit: opts.Body}
+		}
+		if nil != c.Request.Body {
+			c.Request.Body = body
+		}
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		if opts.Body > 0 {
+			writer.capture = &capture{limit: opts.Body}
+			c.Writer = writer
+		}
+
+		panicking := true
+		defer func() {
+			status := c.Writer.Status()
+			if panicking {
+				// logged before the recovery writes the response
+				status = http.StatusInternalServerError
+			}
+			if status < http.StatusBadRequest && sample < 1 && rand.Float64() >= sample {
+				return
+			}
+
+			fields := []any{
+				"route", c.FullPath(),
+				"path", requestPath(c.Request),
+				"method", c.Request.Method,
+				"status", status,
+				"latency", time.Since(start),
+				"client_ip", c.ClientIP(),
+				"user_agent", c.Request.UserAgent(),
+				"request_id", requestID,
+			}
+			if value, ok := c.Get(authorizedContextKey); ok {
+				if authorized, ok := value.(*authorities.Authorized); ok && nil != authorized {
+					fields = append(fields, "account", authorized.ID)
+				}
+			}
+			size := c.Request.ContentLength
+			if body.size > size {
+				size = body.size
+			}
+			fields = append(fields, "request_size", max(size, 0), "response_size", max(c.Writer.Size(), 0))
+			if opts.Headers {
+				fields = append(fields, "headers", redactor.header(c.Request.Header))
+			}
+			if nil != body.capture {
+				fields = append(fields, "request_body", redactor.body(body.capture, c.ContentType()))
+			}
+			if nil != writer.capture {
+				fields = append(fields, "response_body", redactor.body(writer.capture, writer.Header().Get("Content-Type")))
+			}
+
+			switch {
+			case status >= http.StatusInternalServerError:
+				logger.Error("request", fields...)
+			case status >= http.StatusBadRequest:
+				logger.Warn("request", fields...)
+			default:
+				logger.Log(level, "request", fields...)
+			}
+		}()
 		c.Next()
-		if strings.HasSuffix(c.Request.URL.Path, "json") {
-			return
+		panicking = false
+	}
+}
+
+// RequestID returns the id of the request, sent back in the X-Request-ID header.
+func (c *Context) RequestID() string {
+	return c.GetString(requestIDContextKey)
+}
+
+// capture keeps the first limit bytes written.
+type capture struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (c *capture) Write(data []byte) {
+	if room := c.limit - c.buffer.Len(); room < len(data) {
+		c.truncated = true
+		data = data[:max(room, 0)]
+	}
+	c.buffer.Write(data)
+}
+
+// bodyReader counts the bytes of the request body read by the handler.
+type bodyReader struct {
+	io.ReadCloser
+	size    int64
+	capture *capture
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	if nil != r.capture {
+		r.capture.Write(p[:n])
+	}
+	return n, err
+}
+
+type captureWriter struct {
+	gin.ResponseWriter
+	capture *capture
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.capture.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.capture.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// redactor hides the headers and the fields of the bodies by name or by path from the root.
+type redactor struct {
+	headers map[string]bool
+	fields  map[string]bool
+	paths   [][]string
+}
+
+// newRedactor parses the rules, $.a.b and a.b are paths from the root where * matches any field
+// or array element, a bare name is both a header name and a field name at any depth.
+func newRedactor(rules []string) *redactor {
+	r := &redactor{headers: make(map[string]bool), fields: make(map[string]bool)}
+	for _, header := range sensitiveHeaders {
+		r.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	for _, rule := range rules {
+		rule = strings.ReplaceAll(strings.TrimPrefix(rule, "$."), "[*]", ".*")
+		if strings.Contains(rule, ".") {
+			r.paths = append(r.paths, strings.Split(rule, "."))
+			continue
+		}
+		r.headers[http.CanonicalHeaderKey(rule)] = true
+		r.fields[rule] = true
+	}
+	return r
+}
+
+func (r *redactor) header(header http.Header) map[string]string {
+	values := make(map[string]string, len(header))
+	for name := range header {
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			values[name] = redacted
+		} else {
+			values[name] = header.Get(name)
+		}
+	}
+	return values
+}
+
+// body returns the captured body with the fields redacted, a body which can't be parsed
+// is omitted when there are fields to redact.
+func (r *redactor) body(capture *capture, contentType string) string {
+	data := capture.buffer.Bytes()
+	if len(data) == 0 {
+		return ""
+	}
+	suffix := ""
+	if capture.truncated {
+		suffix = "...(truncated)"
+	}
+	if len(r.fields) == 0 && len(r.paths) == 0 {
+		return string(data) + suffix
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded" && !capture.truncated:
+		form, err := url.ParseQuery(string(data))
+		if err != nil {
+			break
 		}
+		for name := range form {
+			if r.fields[name] || r.matches([]string{name}) {
+				form.Set(name, redacted)
+			}
+		}
+		return form.Encode()
+	case (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) && !capture.truncated:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			break
+		}
+		encoded, err := json.Marshal(r.redact(value, nil))
+		if err != nil {
+			break
+		}
+		return string(encoded)
+	}
+	return "(omitted, " + mediaType + " can't be redacted)"
+}
 
-		latency := time.Since(start)
-		status := c.Writer.Status()
+func (r *redactor) redact(value any, path []string) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			childPath := append(path[:len(path):len(path)], key)
+			if r.fields[key] || r.matches(childPath) {
+				value[key] = redacted
+			} else {
+				value[key] = r.redact(child, childPath)
+			}
+		}
+	case []any:
+		for i, child := range value {
+			childPath := append(path[:len(path):len(path)], "*")
+			if r.matches(childPath) {
+				value[i] = redacted
+			} else {
+				value[i] = r.redact(child, childPath)
+			}
+		}
+	}
+	return value
+}
 
-		logger.Info("request",
-			"path", c.Request.URL.Path,
-			"method", c.Request.Method,
-			"status", status,
-			"latency", latency,
-			"client_ip", c.ClientIP(),
-			"user_agent", c.Request.UserAgent(),
-		)
+func (r *redactor) matches(path []string) bool {
+	for _, rule := range r.paths {
+		if len(rule) != len(path) {
+			continue
+		}
+		matched := true
+		for i, segment := range rule {
+			if segment != "*" && segment != path[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
 	}
+	return false
 }
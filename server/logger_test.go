@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestAccessLogger(t *testing.T) {
+	var output bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &output, Level: hclog.Trace, JSONFormat: true})
+	engine := gin.New()
+	engine.Use(AccessLogger(logger, &option.Access{
+		Body:    1024,
+		Headers: true,
+		Redact:  []string{"password", "$.card.number", "items[*].secret", "X-Api-Key"},
+	}))
+	engine.POST("/users/:id", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusBadRequest, "application/json", body)
+	})
+
+	request := httptest.NewRequest(http.MethodPost, "/users/1", strings.NewReader(
+		`{"name":"a","password":"p","card":{"number":"4111","type":"visa"},"items":[{"secret":"s","id":1}]}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(AuthorizationKey, "token")
+	request.Header.Set("X-Api-Key", "key")
+	request.Header.Set(RequestIDKey, "request-1")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+
+	line := output.String()
+	for _, leaked := range []string{`"p"`, "4111", `"s"`, "token", `"key"`} {
+		if strings.Contains(line, leaked) {
+			t.Fatalf("%s leaked in %s", leaked, line)
+		}
+	}
+	for _, expected := range []string{`"@level":"warn"`, `"route":"/users/:id"`, `"request_id":"request-1"`, `visa`, `"request_size":`} {
+		if !strings.Contains(line, expected) {
+			t.Fatalf("expected %s in %s", expected, line)
+		}
+	}
+	if recorder.Header().Get(RequestIDKey) != "request-1" {
+		t.Fatal("expected the request id to be sent back")
+	}
+}
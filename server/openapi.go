@@ -1,46 +1,374 @@
 package server
 
 import (
-	"log"
+	"fmt"
+	"net/http"
 	"net/url"
+	"reflect"
+	"slices"
 	"strings"
 
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/swaggest/openapi-go"
+	"github.com/swaggest/openapi-go/openapi3"
 )
 
-func (m *HttpServer) openapi(path string) {
+const (
+	bearerSecurity   = "bearerAuth"
+	cookieSecurity   = "cookieAuth"
+	internalSecurity = "internalSecret"
+)
+
+var (
+	responseType         = reflect.TypeOf(Response{})
+	paginationType       = reflect.TypeOf(&utils.Pagination{})
+	cursorPaginationType = reflect.TypeOf(&utils.CursorPagination{})
+)
+
+// newReflector creates the reflector of a spec, the paths of the spec are relative to the server url.
+func (m *HttpServer) newReflector(title string, version string, internal bool) *openapi3.Reflector {
+	reflector := openapi3.NewReflector()
+	reflector.Spec = &openapi3.Spec{Openapi: "3.0.3"}
+	reflector.Spec.Info.
+		WithTitle(title).WithDescription("").WithVersion(version)
+
+	server := m.path
+	if server == "" {
+		server = "/"
+	}
+	reflector.Spec.WithServers(openapi3.Server{URL: server})
+
+	if internal {
+		reflector.Spec.SetAPIKeySecurity(internalSecurity, InternalSecretKey, openapi.InHeader, "Secret shared by internal services")
+	} else {
+		reflector.Spec.SetHTTPBearerTokenSecurity(bearerSecurity, "", "Token issued by the authorization, sent in the "+AuthorizationKey+" header")
+		if nil != m.sessions {
+			reflector.Spec.SetAPIKeySecurity(cookieSecurity, m.sessions.name, openapi.InCookie, "Session cookie set by the login")
+		}
+	}
+	return reflector
+}
+
+// SetInfo sets the version and the description of the API in the spec.
+func (m *HttpServer) SetInfo(version string, description string) {
+	m.reflector.Spec.Info.WithVersion(version).WithDescription(description)
+	m.internalReflector.Spec.Info.WithVersion(version).WithDescription(description)
+}
+
+// openapi serves the spec as json or yaml according to the extension of the path,
+// the specs of internal routes require the internal secret and aren't served when none is configured.
+func (m *HttpServer) openapi(path string, reflector *openapi3.Reflector, internal ...bool) {
 	path, _ = url.JoinPath(m.path, path)
 	m.engine.Handle("GET", path, func(ctx *gin.Context) {
+		if len(internal) > 0 && internal[0] && !m.internalSecret(ctx.Request) {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
+		var schema []byte
+		var err error
 		if strings.HasSuffix(path, "json") {
-			schema, err := m.reflector.Spec.MarshalJSON()
-			if err != nil {
-				log.Fatal(err)
-			}
+			schema, err = reflector.Spec.MarshalJSON()
 			ctx.Header("content-type", "application/json")
-			ctx.Writer.Write(schema)
-		}
-
-		if strings.HasSuffix(path, "yaml") || strings.HasSuffix(path, "yml") {
-			schema, err := m.reflector.Spec.MarshalYAML()
-			if err != nil {
-				log.Fatal(err)
-			}
+		} else {
+			schema, err = reflector.Spec.MarshalYAML()
 			ctx.Header("content-type", "application/yaml")
-			ctx.Writer.Write(schema)
 		}
-
+		if err != nil {
+			m.logger.Error("marshal openapi spec", "path", path, "err", err)
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		ctx.Writer.Write(schema)
 	})
 }
 
-func (m *HttpServer) addHandlerDoc(method string, path string, handler *Handler) {
+func (m *HttpServer) addHandlerDoc(reflector *openapi3.Reflector, method string, path string, handler *Handler, internal bool) {
+	endpoint := strings.TrimPrefix(path, m.path)
+	path, params := openapiPath(endpoint)
 
-	operation, _ := m.reflector.NewOperationContext(method, path)
+	operation, err := reflector.NewOperationContext(method, path)
+	if err != nil {
+		m.logger.Warn("openapi operation", "method", method, "path", path, "err", err)
+		return
+	}
 	operation.SetSummary(handler.Name)
 	operation.SetTags(handler.Tags...)
-	operation.AddReqStructure(handler.Args)
-	operation.AddRespStructure(handler.Reply)
+	filters := filterFields(handler.Args)
+
+	if nil != handler.Args {
+		operation.AddReqStructure(handler.Args)
+	}
+	if exposer, ok := operation.(openapi3.OperationExposer); ok {
+		declared := structTags(reflect.TypeOf(handler.Args), "path")
+		for _, param := range params {
+			if slices.Contains(declared, param) {
+				continue
+			}
+			exposer.Operation().Parameters = append(exposer.Operation().Parameters, openapi3.ParameterOrRef{
+				Parameter: &openapi3.Parameter{
+					Name:     param,
+					In:       openapi3.ParameterInPath,
+					Required: &[]bool{true}[0],
+					Schema:   &openapi3.SchemaOrRef{Schema: (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString)},
+				},
+			})
+		}
+		if nil != handler.Idempotency {
+			exposer.Operation().Parameters = append(exposer.Operation().Parameters, openapi3.ParameterOrRef{
+				Parameter: &openapi3.Parameter{
+					Name:        IdempotencyKey,
+					In:          openapi3.ParameterInHeader,
+					Required:    &handler.Idempotency.Required,
+					Description: &[]string{"Key of the request, the retries with the same key get the same response"}[0],
+					Schema:      &openapi3.SchemaOrRef{Schema: (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString)},
+				},
+			})
+		}
+		if nil != handler.Paging {
+			exposer.Operation().Parameters = append(exposer.Operation().Parameters,
+				m.pagingParameters(handler.Paging, structTags(reflect.TypeOf(handler.Args), "query"))...)
+		}
+		if nil != handler.Cache {
+			exposer.Operation().Parameters = append(exposer.Operation().Parameters, openapi3.ParameterOrRef{
+				Parameter: &openapi3.Parameter{
+					Name:        "If-None-Match",
+					In:          openapi3.ParameterInHeader,
+					Description: &[]string{"ETag of the response the client has, 304 is returned when it is still current"}[0],
+					Schema:      &openapi3.SchemaOrRef{Schema: (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString)},
+				},
+			})
+		}
+		if nil != handler.Upload {
+			uploadRequestBody(exposer.Operation(), handler.Upload)
+		}
+		if nil != filters && !slices.Contains(structTags(reflect.TypeOf(handler.Args), "query"), FilterParam) {
+			exposer.Operation().Parameters = append(exposer.Operation().Parameters, openapi3.ParameterOrRef{
+				Parameter: &openapi3.Parameter{
+					Name:        FilterParam,
+					In:          openapi3.ParameterInQuery,
+					Description: &[]string{filterDescription(filters)}[0],
+					Schema:      &openapi3.SchemaOrRef{Schema: (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString)},
+				},
+			})
+		}
+	}
+
+	secured := !internal && m.secured(endpoint, handler)
+	operation.AddRespStructure(responseEnvelope(handler.Reply, handler.Paging), openapi.WithHTTPStatus(http.StatusOK),
+		envelopeCodes(internal, secured, handler))
+	operation.AddRespStructure(new(string), openapi.WithContentType("text/plain"), openapi.WithHTTPStatus(http.StatusBadRequest))
+	if nil != handler.Paging || nil != filters {
+		operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusBadRequest))
+	}
+	if nil != handler.Cache {
+		operation.AddRespStructure(nil, openapi.WithHTTPStatus(http.StatusNotModified))
+	}
+	if nil != handler.Upload {
+		operation.AddRespStructure(new(string), openapi.WithContentType("text/plain"), openapi.WithHTTPStatus(http.StatusRequestEntityTooLarge))
+		operation.AddRespStructure(new(string), openapi.WithContentType("text/plain"), openapi.WithHTTPStatus(http.StatusUnsupportedMediaType))
+	}
+	if nil != handler.Idempotency {
+		operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusConflict))
+		if nil == handler.Upload {
+			operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusRequestEntityTooLarge))
+		}
+	}
+	if nil != m.csrf && !internal && !handler.CSRFExempt && method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions {
+		operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusForbidden))
+	}
+	if nil != handler.RateLimit || nil != m.rateLimit {
+		operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusTooManyRequests))
+	}
+	if m.routeTimeout(handler) > 0 {
+		operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusServiceUnavailable))
+	}
+	operation.AddRespStructure(new(Response), openapi.WithHTTPStatus(http.StatusInternalServerError))
+
+	if internal {
+		operation.AddSecurity(internalSecurity)
+	} else if secured {
+		operation.AddSecurity(bearerSecurity)
+		if nil != m.sessions {
+			operation.AddSecurity(cookieSecurity)
+		}
+	}
+
+	if err := reflector.AddOperation(operation); err != nil {
+		m.logger.Warn("openapi operation", "method", method, "path", path, "err", err)
+	}
+}
 
-	m.reflector.AddOperation(operation)
+// envelopeCodes describes the codes of the Response sent with 200 when the route refuses the request,
+// the authorization failures are written by Context.WriteFail.
+func envelopeCodes(internal bool, secured bool, handler *Handler) openapi.ContentOption {
+	codes := []string{"0 on success"}
+	switch {
+	case internal:
+		codes = append(codes, "401 without the internal secret")
+	case secured:
+		codes = append(codes, "401 without a valid token")
+		if handler.Permission != "" {
+			codes = append(codes, "403 without the permission "+handler.Permission)
+		}
+	}
+	return func(cu *openapi.ContentUnit) {
+		cu.Description = "The code of the response is " + strings.Join(codes, ", ")
+	}
+}
 
+// pagingParameters returns the query parameters of the pagination not declared by the args.
+func (m *HttpServer) pagingParameters(paging *Paging, declared []string) []openapi3.ParameterOrRef {
+	size, maxSize := m.pagination.sizes(paging)
+	integer := func(minimum int64, maximum int64, def int) *openapi3.SchemaOrRef {
+		schema := (&openapi3.Schema{}).WithType(openapi3.SchemaTypeInteger).WithMinimum(float64(minimum)).WithDefault(def)
+		if maximum > 0 {
+			schema.WithMaximum(float64(maximum))
+		}
+		return &openapi3.SchemaOrRef{Schema: schema}
+	}
+	parameters := map[string]*openapi3.Parameter{
+		"size": {Description: &[]string{"Number of items of the page"}[0], Schema: integer(1, int64(maxSize), size)},
+	}
+	if paging.Cursor {
+		parameters["cursor"] = &openapi3.Parameter{
+			Description: &[]string{"Cursor of the next or the previous page, from the pagination of a response"}[0],
+			Schema:      &openapi3.SchemaOrRef{Schema: (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString)},
+		}
+	} else {
+		parameters["page"] = &openapi3.Parameter{Description: &[]string{"Number of the page, from 1"}[0], Schema: integer(1, maxPage, 1)}
+	}
+	if len(paging.Sort) > 0 {
+		description := "Comma separated fields to sort by, descending when prefixed with -, among " + strings.Join(paging.Sort, ", ")
+		schema := (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString)
+		if paging.DefaultSort != "" {
+			schema.WithDefault(paging.DefaultSort)
+		}
+		parameters["sort"] = &openapi3.Parameter{Description: &description, Schema: &openapi3.SchemaOrRef{Schema: schema}}
+	}
+
+	var result []openapi3.ParameterOrRef
+	for _, name := range []string{"page", "cursor", "size", "sort"} {
+		parameter, ok := parameters[name]
+		if !ok || slices.Contains(declared, name) {
+			continue
+		}
+		parameter.Name = name
+		parameter.In = openapi3.ParameterInQuery
+		result = append(result, openapi3.ParameterOrRef{Parameter: parameter})
+	}
+	return result
+}
+
+// uploadRequestBody documents the files of the upload as a multipart body, along with the form values of the args.
+func uploadRequestBody(operation *openapi3.Operation, upload *Upload) {
+	fields := upload.Fields
+	if len(fields) == 0 {
+		fields = []string{"file"}
+	}
+	description := fmt.Sprintf("At most %d bytes", upload.maxSize())
+	if len(upload.Types) > 0 {
+		description += " of " + strings.Join(upload.Types, ", ")
+	}
+	files := (&openapi3.Schema{}).WithType(openapi3.SchemaTypeObject)
+	for _, field := range fields {
+		file := (&openapi3.Schema{}).WithType(openapi3.SchemaTypeString).WithFormat("binary").WithDescription(description)
+		if len(fields) == 1 && upload.maxFiles() > 1 {
+			file = (&openapi3.Schema{}).WithType(openapi3.SchemaTypeArray).WithMaxItems(int64(upload.maxFiles())).
+				WithItems(openapi3.SchemaOrRef{Schema: file})
+		}
+		files.WithPropertiesItem(field, openapi3.SchemaOrRef{Schema: file})
+	}
+
+	schema := &openapi3.SchemaOrRef{Schema: files}
+	if nil != operation.RequestBody && nil != operation.RequestBody.RequestBody {
+		if media, ok := operation.RequestBody.RequestBody.Content["multipart/form-data"]; ok && nil != media.Schema {
+			schema = &openapi3.SchemaOrRef{Schema: (&openapi3.Schema{}).WithAllOf(*media.Schema, *schema)}
+		}
+	}
+	operation.RequestBody = &openapi3.RequestBodyOrRef{RequestBody: &openapi3.RequestBody{
+		Required: &[]bool{true}[0],
+		Content:  map[string]openapi3.MediaType{"multipart/form-data": {Schema: schema}},
+	}}
+}
+
+// secured reports whether the route requires a token, the same way authorize decides it.
+func (m *HttpServer) secured(endpoint string, handler *Handler) bool {
+	switch handler.Policy {
+	case authorities.AuthorizationPolicyAllow:
+		return false
+	case authorities.AuthorizationPolicyDeny:
+		return true
+	}
+	if handler.Permission != "" {
+		return true
+	}
+	settings := m.authorization.Settings()
+	if slices.Contains(settings.AnonEndpoints, endpoint) {
+		return false
+	}
+	return settings.DefaultPolicy != authorities.AuthorizationPolicyAllow
+}
+
+// openapiPath converts the gin parameters :id and *path to {id} and {path}.
+func openapiPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// responseEnvelope returns a Response whose Content has the type of the reply,
+// and whose Pagination is a utils.Pagination or a utils.CursorPagination when the route is paginated.
+func responseEnvelope(reply any, paging *Paging) any {
+	if nil == reply && nil == paging {
+		return new(Response)
+	}
+	fields := make([]reflect.StructField, 0, responseType.NumField())
+	for i := 0; i < responseType.NumField(); i++ {
+		field := responseType.Field(i)
+		if field.Name == "Content" && nil != reply {
+			field.Type = reflect.TypeOf(reply)
+		}
+		if field.Name == "Pagination" && nil != paging {
+			field.Type = paginationType
+			if paging.Cursor {
+				field.Type = cursorPaginationType
+			}
+		}
+		fields = append(fields, field)
+	}
+	return reflect.New(reflect.StructOf(fields)).Interface()
+}
+
+// structTags returns the names of the tag on the fields, including the embedded ones.
+func structTags(t reflect.Type, tag string) []string {
+	if nil == t {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			names = append(names, structTags(field.Type, tag)...)
+			continue
+		}
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+)
+
+func TestOpenAPI(t *testing.T) {
+	type order struct {
+		ID     string `json:"id"`
+		Amount int    `json:"amount"`
+	}
+	type orderArgs struct {
+		ID string `path:"id"`
+	}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny,
+		AnonEndpoints: []string{"/status"}, InternalSecret: "secret"}, nil)
+	handler := func(ctx *Context) error { return nil }
+	srv.Get("/orders/:id", &Handler{Name: "order", Permission: "orders", Args: orderArgs{}, Reply: order{}, Func: handler})
+	srv.Get("/files/:bucket/*key", &Handler{Func: handler})
+	srv.Get("/status", &Handler{Func: handler})
+	srv.Internal(http.MethodGet, "/sync", &Handler{Func: handler})
+
+	type operation struct {
+		Parameters []struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+		Security  []map[string][]string `json:"security"`
+		Responses map[string]struct {
+			Description string `json:"description"`
+		} `json:"responses"`
+	}
+	spec := func(target string, header ...string) map[string]map[string]operation {
+		recorder := serve(srv, http.MethodGet, target, header...)
+		var spec struct {
+			Paths map[string]map[string]operation `json:"paths"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &spec); err != nil {
+			t.Fatalf("unexpected spec of %s: %d %s", target, recorder.Code, recorder.Body.String())
+		}
+		return spec.Paths
+	}
+	params := func(op operation) []string {
+		var names []string
+		for _, param := range op.Parameters {
+			names = append(names, param.In+":"+param.Name)
+		}
+		return names
+	}
+	schemes := func(op operation) []string {
+		var names []string
+		for _, security := range op.Security {
+			for name := range security {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	public := spec("/openapi.json")
+	if _, ok := public["/internal/sync"]; ok {
+		t.Fatal("expected the internal routes apart from the public spec")
+	}
+	get := public["/orders/{id}"]["get"]
+	if !slices.Equal(params(get), []string{"path:id"}) || !slices.Equal(schemes(get), []string{bearerSecurity}) {
+		t.Fatalf("unexpected operation %+v", get)
+	}
+	if description := get.Responses["200"].Description; !strings.Contains(description, "401") || !strings.Contains(description, "403 without the permission orders") {
+		t.Fatalf("expected the codes of the failures documented, got %q", description)
+	}
+	if _, ok := get.Responses["401"]; ok {
+		t.Fatal("expected no 401 status, the failures are sent with 200")
+	}
+	if files := public["/files/{bucket}/{key}"]["get"]; !slices.Equal(params(files), []string{"path:bucket", "path:key"}) {
+		t.Fatalf("unexpected parameters %v", params(files))
+	}
+	status := public["/status"]["get"]
+	if len(status.Security) != 0 || strings.Contains(status.Responses["200"].Description, "401") {
+		t.Fatalf("expected the anonymous endpoint unsecured, got %+v", status)
+	}
+
+	if recorder := serve(srv, http.MethodGet, "/internal/openapi.json"); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the internal spec to need the secret, got %d", recorder.Code)
+	}
+	internal := spec("/internal/openapi.json", InternalSecretKey, "secret")
+	sync := internal["/internal/sync"]["get"]
+	if _, ok := internal["/orders/{id}"]; ok || !slices.Equal(schemes(sync), []string{internalSecurity}) ||
+		!strings.Contains(sync.Responses["200"].Description, "401 without the internal secret") {
+		t.Fatalf("unexpected internal spec %+v", internal)
+	}
+
+	yaml := serve(srv, http.MethodGet, "/openapi.yaml")
+	if yaml.Header().Get("Content-Type") != "application/yaml" || !strings.HasPrefix(yaml.Body.String(), "openapi: 3.0.3") ||
+		!strings.Contains(yaml.Body.String(), "/orders/{id}:") {
+		t.Fatalf("unexpected yaml spec %s %s", yaml.Header().Get("Content-Type"), yaml.Body.String())
+	}
+}
@@ -0,0 +1,404 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/option"
+	"github.com/deepissue/core/utils"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	paginationContextKey  = "core.pagination"
+	pageRequestContextKey = "core.page_request"
+)
+
+// maxPage bounds the page number, the offset of the page stays far from overflowing
+const maxPage = 1000000
+
+var errCursor = errors.New("invalid cursor")
+
+// Paging enables the validated pagination parameters of a route: page or cursor, size and sort.
+// A request with a page or a size out of range, a sort field not allowed or a cursor not issued
+// by the server is refused with 400, the parsed parameters are returned by Context.PageRequest.
+type Paging struct {
+	// DefaultSize and MaxSize override the sizes of the server when set
+	DefaultSize int
+	MaxSize     int
+	// Sort lists the fields the sort parameter may order by, the parameter is refused when empty
+	Sort []string
+	// DefaultSort applies when the request has no sort, such as "-created_at"
+	DefaultSort string
+	// Key is the unique field ending the order so that the rows of a keyset page are distinct, id by default
+	Key string
+	// Cursor enables the keyset pagination, the cursor parameter replaces page
+	Cursor bool
+}
+
+// defaultSort reports whether the field is one of the default sort, which needs no allowing.
+func (p *Paging) defaultSort(field string) bool {
+	for _, sorted := range strings.Split(p.DefaultSort, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(sorted), "-") == field {
+			return true
+		}
+	}
+	return false
+}
+
+// SortField is a field of the sort parameter, "-name" sorts by name descending.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Cursor is the position of a keyset page: the values of the sort fields of the row it starts after.
+type Cursor struct {
+	Values   []any  `json:"v"`
+	Sort     string `json:"s"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// PageRequest is the pagination of the request, either a page number or a cursor.
+type PageRequest struct {
+	Page   int
+	Size   int
+	Sort   []SortField
+	Cursor *Cursor
+	// keyset is set on the routes with Paging.Cursor, the sort fields then end with the key
+	keyset bool
+}
+
+// Offset returns the number of rows before the page, the page is brought into range.
+func (p *PageRequest) Offset() int {
+	return (min(max(p.Page, 1), maxPage) - 1) * max(p.Size, 0)
+}
+
+// Limit returns the number of rows to fetch, a keyset page fetches one more row to know whether more follow.
+func (p *PageRequest) Limit() int {
+	if p.keyset {
+		return p.Size + 1
+	}
+	return p.Size
+}
+
+// Backward reports whether the cursor goes back to the previous page, the rows are then fetched
+// in the reverse order by OrderBy and must be reversed before being written.
+func (p *PageRequest) Backward() bool {
+	return nil != p.Cursor && p.Cursor.Backward
+}
+
+// OrderBy returns the ORDER BY clause of the sort, without the keyword, such as "created_at DESC, id DESC".
+// The fields are from the allow-list of the route.
+func (p *PageRequest) OrderBy() string {
+	clauses := make([]string, 0, len(p.Sort))
+	for _, field := range p.Sort {
+		if field.Desc != p.Backward() {
+			clauses = append(clauses, field.Field+" DESC")
+		} else {
+			clauses = append(clauses, field.Field+" ASC")
+		}
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// Keyset returns the WHERE condition selecting the rows after the cursor and its arguments,
+// bound with ? placeholders. The condition is empty on the first page.
+//
+//	(created_at < ?) OR (created_at = ? AND id < ?)
+func (p *PageRequest) Keyset() (string, []any) {
+	if nil == p.Cursor {
+		return "", nil
+	}
+	var args []any
+	conditions := make([]string, 0, len(p.Sort))
+	for i, field := range p.Sort {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, p.Sort[j].Field+" = ?")
+			args = append(args, p.Cursor.Values[j])
+		}
+		operator := " > ?"
+		if field.Desc != p.Backward() {
+			operator = " < ?"
+		}
+		terms = append(terms, field.Field+operator)
+		args = append(args, p.Cursor.Values[i])
+		conditions = append(conditions, "("+strings.Join(terms, " AND ")+")")
+	}
+	return strings.Join(conditions, " OR "), args
+}
+
+func (p *PageRequest) sortString() string {
+	fields := make([]string, 0, len(p.Sort))
+	for _, field := range p.Sort {
+		if field.Desc {
+			fields = append(fields, "-"+field.Field)
+		} else {
+			fields = append(fields, field.Field)
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// pagination holds the sizes of the server and the key signing the cursors.
+type pagination struct {
+	size    int
+	maxSize int
+	key     *signingKey
+}
+
+func newPagination(opts *option.Pagination) *pagination {
+	p := &pagination{size: opts.Size, maxSize: opts.MaxSize, key: newSigningKey(opts.Secret, "http.pagination.secret")}
+	if p.maxSize <= 0 {
+		p.maxSize = 100
+	}
+	if p.size <= 0 || p.size > p.maxSize {
+		p.size = min(20, p.maxSize)
+	}
+	return p
+}
+
+// register checks the paging of a route, the cursors need a key shared by the instances.
+func (p *pagination) register(paging *Paging, logger hclog.Logger) {
+	if paging.Cursor {
+		p.key.warn(logger)
+	}
+}
+
+func (p *pagination) sizes(paging *Paging) (int, int) {
+	size, maxSize := p.size, p.maxSize
+	if nil != paging && paging.MaxSize > 0 {
+		maxSize = paging.MaxSize
+	}
+	if nil != paging && paging.DefaultSize > 0 {
+		size = paging.DefaultSize
+	}
+	return min(size, maxSize), maxSize
+}
+
+// parse validates the pagination parameters of the request.
+func (p *pagination) parse(ctx *Context, paging *Paging) (*PageRequest, error) {
+	size, maxSize := p.sizes(paging)
+	request := &PageRequest{Page: 1, Size: size, keyset: paging.Cursor}
+	if value := ctx.Query("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSize {
+			return nil, fmt.Errorf("size must be between 1 and %d", maxSize)
+		}
+		request.Size = n
+	}
+	if value := ctx.Query("page"); value != "" && !paging.Cursor {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPage {
+			return nil, fmt.Errorf("page must be between 1 and %d", maxPage)
+		}
+		request.Page = n
+	}
+
+	sort := ctx.Query("sort")
+	if sort == "" {
+		sort = paging.DefaultSort
+	}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sorted := SortField{Field: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if !slices.Contains(paging.Sort, sorted.Field) && !paging.defaultSort(sorted.Field) {
+			return nil, fmt.Errorf("sort by %s is not allowed", sorted.Field)
+		}
+		if slices.ContainsFunc(request.Sort, func(s SortField) bool { return s.Field == sorted.Field }) {
+			return nil, fmt.Errorf("sort by %s is repeated", sorted.Field)
+		}
+		request.Sort = append(request.Sort, sorted)
+	}
+	if !paging.Cursor {
+		return request, nil
+	}
+
+	key := paging.Key
+	if key == "" {
+		key = "id"
+	}
+	if !slices.ContainsFunc(request.Sort, func(s SortField) bool { return s.Field == key }) {
+		desc := len(request.Sort) > 0 && request.Sort[len(request.Sort)-1].Desc
+		request.Sort = append(request.Sort, SortField{Field: key, Desc: desc})
+	}
+	if value := ctx.Query("cursor"); value != "" {
+		cursor, err := p.decode(value)
+		if err != nil || cursor.Sort != request.sortString() || len(cursor.Values) != len(request.Sort) {
+			return nil, errCursor
+		}
+		request.Cursor = cursor
+	}
+	return request, nil
+}
+
+// encode returns the cursor as the base64 of its JSON and its signature.
+func (p *pagination) encode(cursor *Cursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload))
+}
+
+func (p *pagination) decode(value string) (*Cursor, error) {
+	encoded, signature, found := strings.Cut(value, ".")
+	if !found {
+		return nil, errCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sum, p.sign(payload)) {
+		return nil, errCursor
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	cursor := &Cursor{}
+	if err := decoder.Decode(cursor); err != nil {
+		return nil, err
+	}
+	// the numbers are restored as int64 when they are integers, float64 otherwise
+	for i, value := range cursor.Values {
+		if number, ok := value.(json.Number); ok {
+			if n, err := number.Int64(); err == nil {
+				cursor.Values[i] = n
+			} else {
+				cursor.Values[i], _ = number.Float64()
+			}
+		}
+	}
+	return cursor, nil
+}
+
+func (p *pagination) sign(payload []byte) []byte {
+	mac := p.key.mac()
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// paginated parses the pagination parameters of the routes with Paging, it reports whether
+// the request was refused.
+func (m *HttpServer) paginated(ctx *Context, handler *Handler) bool {
+	ctx.Set(paginationContextKey, m.pagination)
+	if nil == handler.Paging {
+		return false
+	}
+	request, err := m.pagination.parse(ctx, handler.Paging)
+	if err != nil {
+		ctx.Negotiate(http.StatusBadRequest, &Response{
+			Code:      http.StatusBadRequest,
+			Message:   err.Error(),
+			Timestamp: time.Now().Local().Unix(),
+		})
+		return true
+	}
+	ctx.Set(pageRequestContextKey, request)
+	return false
+}
+
+// PageRequest returns the validated pagination of a route with Paging, or the page and the size
+// brought into range on the other routes.
+func (c *Context) PageRequest() *PageRequest {
+	if value, ok := c.Get(pageRequestContextKey); ok {
+		return value.(*PageRequest)
+	}
+	p := &pagination{size: 20, maxSize: 100}
+	if value, ok := c.Get(paginationContextKey); ok {
+		p = value.(*pagination)
+	}
+	request := &PageRequest{Page: 1, Size: p.size}
+	if n, err := strconv.Atoi(c.Query("page")); err == nil && n > 0 {
+		request.Page = min(n, maxPage)
+	}
+	if n, err := strconv.Atoi(c.Query("size")); err == nil && n > 0 {
+		request.Size = min(n, p.maxSize)
+	}
+	return request
+}
+
+// OffsetPagination returns the pagination of the page among the total number of rows.
+func (c *Context) OffsetPagination(total int64) *utils.Pagination {
+	request := c.PageRequest()
+	return utils.NewPagination(total, int64(request.Size), request.Page)
+}
+
+// CursorPagination returns the pagination of a keyset page, from the values of the sort fields
+// of its first and last rows. more reports whether the query returned more rows than the size.
+func (c *Context) CursorPagination(more bool, first []any, last []any) *utils.CursorPagination {
+	request := c.PageRequest()
+	page := &utils.CursorPagination{Size: request.Size}
+	value, ok := c.Get(paginationContextKey)
+	if !ok || len(first) == 0 {
+		return page
+	}
+	p := value.(*pagination)
+	sort := request.sortString()
+	// going backward, the next page is the one the cursor came from
+	next, previous := more, nil != request.Cursor
+	if request.Backward() {
+		next, previous = true, more
+	}
+	if next {
+		page.Next = p.encode(&Cursor{Values: last, Sort: sort})
+	}
+	if previous {
+		page.Previous = p.encode(&Cursor{Values: first, Sort: sort, Backward: true})
+	}
+	page.HasMore = next
+	return page
+}
+
+// setLinks sets the Link header of the pagination with the next, prev, first and last pages.
+func (c *Context) setLinks(pagination any) {
+	link := func(rel string, set func(url.Values)) string {
+		query := c.Request.URL.Query()
+		set(query)
+		u := url.URL{Path: requestPath(c.Request), RawQuery: query.Encode()}
+		return "<" + u.String() + `>; rel="` + rel + `"`
+	}
+	var links []string
+	switch pagination := pagination.(type) {
+	case *utils.CursorPagination:
+		if nil == pagination || (pagination.Next == "" && pagination.Previous == "") {
+			break
+		}
+		if pagination.Next != "" {
+			links = append(links, link("next", func(q url.Values) { q.Set("cursor", pagination.Next) }))
+		}
+		if pagination.Previous != "" {
+			links = append(links, link("prev", func(q url.Values) { q.Set("cursor", pagination.Previous) }))
+		}
+		links = append(links, link("first", func(q url.Values) { q.Del("cursor") }))
+	case *utils.Pagination:
+		if nil == pagination || pagination.Page <= 0 {
+			break
+		}
+		page := func(rel string, n int) string {
+			return link(rel, func(q url.Values) { q.Set("page", strconv.Itoa(n)) })
+		}
+		if pagination.HasMore {
+			links = append(links, page("next", pagination.Page+1))
+		}
+		if pagination.Page > 1 {
+			links = append(links, page("prev", min(pagination.Page-1, pagination.TotalPages)))
+		}
+		links = append(links, page("first", 1), page("last", pagination.TotalPages))
+	}
+	if len(links) > 0 {
+		c.Writer.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
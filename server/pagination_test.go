@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestPagination(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger(), pagination: newPagination(&option.Pagination{Size: 20, MaxSize: 50, Secret: "secret"})}
+	engine := gin.New()
+	var request *PageRequest
+	paginated := func(paging *Paging, write func(*Context)) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			ctx := NewContext(c)
+			if !srv.paginated(ctx, &Handler{Paging: paging}) {
+				request = ctx.PageRequest()
+				write(ctx)
+			}
+		}
+	}
+	engine.GET("/orders", paginated(&Paging{Sort: []string{"created_at", "amount"}, DefaultSort: "-created_at"}, func(ctx *Context) {
+		ctx.WriteDataWithPagination(nil, ctx.OffsetPagination(45))
+	}))
+	var first, last []any
+	engine.GET("/events", paginated(&Paging{Sort: []string{"created_at"}, DefaultSort: "-created_at", Cursor: true}, func(ctx *Context) {
+		ctx.WriteDataWithPagination(nil, ctx.CursorPagination(true, first, last))
+	}))
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+
+	for _, target := range []string{"/orders?page=0", "/orders?page=9223372036854775807", "/orders?page=1000001", "/orders?size=51", "/orders?size=x", "/orders?sort=password", "/orders?sort=amount,-amount"} {
+		if recorder := get(target); recorder.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be refused, got %d", target, recorder.Code)
+		}
+	}
+	recorder := get("/orders?page=2&size=20&sort=amount")
+	if recorder.Code != http.StatusOK || request.Offset() != 20 || request.OrderBy() != "amount ASC" {
+		t.Fatalf("unexpected request %+v", request)
+	}
+	links := recorder.Header().Get("Link")
+	if !strings.Contains(links, `page=3&size=20&sort=amount>; rel="next"`) || !strings.Contains(links, `page=1&size=20&sort=amount>; rel="prev"`) ||
+		!strings.Contains(links, `page=3&size=20&sort=amount>; rel="last"`) {
+		t.Fatalf("unexpected links %s", links)
+	}
+	if fields := paginationFields(t, get("/orders?page=9")); !reflect.DeepEqual(fields, []string{"has_more", "page", "size", "total", "total_pages"}) {
+		t.Fatalf("expected the offset fields to be always present, got %v", fields)
+	}
+
+	first, last = []any{"2024-05-02", int64(9)}, []any{"2024-05-01", int64(7)}
+	recorder = get("/events")
+	if request.OrderBy() != "created_at DESC, id DESC" || request.Limit() != 21 {
+		t.Fatalf("unexpected keyset request %+v", request)
+	}
+	next := nextCursor(t, recorder.Header().Get("Link"))
+	if fields := paginationFields(t, recorder); !reflect.DeepEqual(fields, []string{"has_more", "next", "previous", "size"}) {
+		t.Fatalf("expected the cursor fields to be always present, got %v", fields)
+	}
+	get("/events?cursor=" + next)
+	condition, args := request.Keyset()
+	if condition != "(created_at < ?) OR (created_at = ? AND id < ?)" || !reflect.DeepEqual(args, []any{"2024-05-01", "2024-05-01", int64(7)}) {
+		t.Fatalf("unexpected keyset %s %v", condition, args)
+	}
+	if recorder := get("/events?sort=created_at&cursor=" + next); recorder.Code != http.StatusBadRequest {
+		t.Fatal("expected the cursor of another sort to be refused")
+	}
+	if recorder := get("/events?cursor=" + strings.Replace(next, ".", "x.", 1)); recorder.Code != http.StatusBadRequest {
+		t.Fatal("expected the tampered cursor to be refused")
+	}
+}
+
+func paginationFields(t *testing.T, recorder *httptest.ResponseRecorder) []string {
+	var response struct {
+		Pagination map[string]any `json:"pagination"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unexpected body %s", recorder.Body.String())
+	}
+	return slices.Sorted(maps.Keys(response.Pagination))
+}
+
+func nextCursor(t *testing.T, links string) string {
+	_, next, _ := strings.Cut(links, "cursor=")
+	next, _, _ = strings.Cut(next, ">")
+	if next == "" {
+		t.Fatalf("expected a next link in %s", links)
+	}
+	return next
+}
+
+func TestPageRequestWithoutPaging(t *testing.T) {
+	engine := gin.New()
+	var page, size int
+	engine.GET("/orders", func(c *gin.Context) {
+		ctx := NewContext(c)
+		ctx.Set(paginationContextKey, newPagination(&option.Pagination{Size: 10, MaxSize: 30}))
+		page, size = ctx.PageNumber(), ctx.PageSize()
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders?page=-3&size=1000000", nil))
+	if page != 1 || size != 30 {
+		t.Fatalf("expected the page and the size in range, got %d %d", page, size)
+	}
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders?page=9223372036854775807", nil))
+	if page != maxPage {
+		t.Fatalf("expected the page to be capped, got %d", page)
+	}
+	if offset := (&PageRequest{Page: math.MaxInt, Size: 100}).Offset(); offset != (maxPage-1)*100 {
+		t.Fatalf("expected the offset of the last page allowed, got %d", offset)
+	}
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type RateLimitAlgorithm string
+
+const (
+	RateLimitTokenBucket   RateLimitAlgorithm = "token_bucket"
+	RateLimitSlidingWindow RateLimitAlgorithm = "sliding_window"
+)
+
+// RateLimitKey selects what a limit is counted against
+type RateLimitKey string
+
+const (
+	RateLimitKeyIP      RateLimitKey = "ip"
+	RateLimitKeyClient  RateLimitKey = "client"
+	RateLimitKeyAccount RateLimitKey = "account"
+)
+
+// RateLimit allows Limit requests per Window for each key.
+// Burst is the capacity of the token bucket, it defaults to Limit.
+type RateLimit struct {
+	Algorithm RateLimitAlgorithm
+	Key       RateLimitKey
+	Limit     int
+	Window    time.Duration
+	Burst     int
+}
+
+func (l *RateLimit) validate() error {
+	if l.Limit <= 0 || l.Window <= 0 {
+		return errors.New("rate limit needs a positive limit and window")
+	}
+	return nil
+}
+
+func (l *RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Limit
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimiter is the storage of the rate limit counters.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit *RateLimit) (*RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type slidingWindow struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+type memoryRateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	windows map[string]*slidingWindow
+	swept   time.Time
+	maxIdle time.Duration
+}
+
+// NewMemoryRateLimiter keeps the counters in the process, for single instance deployments.
+func NewMemoryRateLimiter() RateLimiter {
+	return &memoryRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		windows: make(map[string]*slidingWindow),
+		swept:   time.Now(),
+	}
+}
+
+func (m *memoryRateLimiter) Allow(ctx context.Context, key string, limit *RateLimit) (*RateLimitResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if limit.Window*2 > m.maxIdle {
+		m.maxIdle = limit.Window * 2
+	}
+	m.sweep(now)
+
+	if limit.Algorithm == RateLimitSlidingWindow {
+		window, ok := m.windows[key]
+		if !ok {
+			window = &slidingWindow{start: now.Truncate(limit.Window)}
+			m.windows[key] = window
+		}
+		return window.allow(now, limit), nil
+	}
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.burst()), last: now}
+		m.buckets[key] = bucket
+	}
+	return bucket.allow(now, limit), nil
+}
+
+// sweep drops the counters idle for longer than the largest window seen, once a minute.
+func (m *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.last) > m.maxIdle {
+			delete(m.buckets, key)
+		}
+	}
+	for key, window := range m.windows {
+		if now.Sub(window.start) > m.maxIdle {
+			delete(m.windows, key)
+		}
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time, limit *RateLimit) *RateLimitResult {
+	rate := float64(limit.Limit) / float64(limit.Window)
+	burst := float64(limit.burst())
+	b.tokens = math.Min(burst, b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	result := tokenBucketResult(limit, b.tokens, rate)
+	if result.Allowed {
+		b.tokens--
+	}
+	return result
+}
+
+// tokenBucketResult consumes a token when there is one, tokens is the amount before consuming.
+func tokenBucketResult(limit *RateLimit, tokens float64, rate float64) *RateLimitResult {
+	result := &RateLimitResult{Limit: limit.burst()}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) / rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration((float64(limit.burst()) - tokens) / rate)
+	return result
+}
+
+// allow approximates a sliding window by weighting the previous fixed window.
+func (w *slidingWindow) allow(now time.Time, limit *RateLimit) *RateLimitResult {
+	start := now.Truncate(limit.Window)
+	if start.Sub(w.start) >= 2*limit.Window {
+		w.previous, w.current = 0, 0
+	} else if start.After(w.start) {
+		w.previous, w.current = w.current, 0
+	}
+	w.start = start
+
+	elapsed := now.Sub(start)
+	count := slidingWindowCount(w.previous, w.current, elapsed, limit.Window)
+	result := slidingWindowResult(limit, count, elapsed)
+	if result.Allowed {
+		w.current++
+	}
+	return result
+}
+
+func slidingWindowCount(previous, current int, elapsed, window time.Duration) float64 {
+	return float64(previous)*float64(window-elapsed)/float64(window) + float64(current)
+}
+
+func slidingWindowResult(limit *RateLimit, count float64, elapsed time.Duration) *RateLimitResult {
+	result := &RateLimitResult{Limit: limit.Limit, Reset: limit.Window - elapsed}
+	if count < float64(limit.Limit) {
+		result.Allowed = true
+		count++
+	} else {
+		result.RetryAfter = result.Reset
+	}
+	result.Remaining = max(0, limit.Limit-int(math.Ceil(count)))
+	return result
+}
+
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1]) or burst
+local ts = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local before = tokens
+if tokens >= 1 then
+	tokens = tokens - 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ttl)
+return tostring(before)
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local count = previous * (window - elapsed) / window + current
+if count < limit then
+	redis.call('INCR', KEYS[1])
+	redis.call('PEXPIRE', KEYS[1], window * 2)
+end
+return tostring(count)
+`)
+
+type redisRateLimiter struct {
+	redis  redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRateLimiter shares the counters between instances through redis.
+func NewRedisRateLimiter(client redis.UniversalClient, prefix string) RateLimiter {
+	if prefix == "" {
+		prefix = "ratelimit:"
+	}
+	return &redisRateLimiter{redis: client, prefix: prefix}
+}
+
+func (r *redisRateLimiter) Allow(ctx context.Context, key string, limit *RateLimit) (*RateLimitResult, error) {
+	now := time.Now()
+	if limit.Algorithm == RateLimitSlidingWindow {
+		start := now.Truncate(limit.Window)
+		window := limit.Window.Milliseconds()
+		elapsed := now.Sub(start)
+		// the hash tag keeps both windows of a key in the same cluster slot
+		keys := []string{
+			fmt.Sprintf("%s{%s}:%d", r.prefix, key, start.UnixMilli()),
+			fmt.Sprintf("%s{%s}:%d", r.prefix, key, start.Add(-limit.Window).UnixMilli()),
+		}
+		value, err := slidingWindowScript.Run(ctx, r.redis, keys, limit.Limit, window, elapsed.Milliseconds()).Text()
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return slidingWindowResult(limit, count, elapsed), nil
+	}
+
+	rate := float64(limit.Limit) / float64(limit.Window.Milliseconds())
+	ttl := int64(float64(limit.burst())/rate) + 1000
+	value, err := tokenBucketScript.Run(ctx, r.redis, []string{r.prefix + key},
+		strconv.FormatFloat(rate, 'f', -1, 64), limit.burst(), now.UnixMilli(), ttl).Text()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return tokenBucketResult(limit, tokens, float64(limit.Limit)/float64(limit.Window)), nil
+}
+
+// SetRateLimiter sets the storage of the counters and the limit applied to every route,
+// global may be nil to only apply the limits of the handlers.
+func (m *HttpServer) SetRateLimiter(limiter RateLimiter, global *RateLimit) error {
+	if nil != global {
+		if err := global.validate(); err != nil {
+			return err
+		}
+	}
+	m.rateLimiter = limiter
+	m.rateLimit = global
+	return nil
+}
+
+// rateLimited applies the global and the route limits whose key is available in this phase,
+// limits by account are applied once the request is authorized. It writes 429 when a limit is exceeded.
+func (m *HttpServer) rateLimited(ctx *Context, handler *Handler, authorized bool) bool {
+	if nil == m.rateLimiter {
+		return false
+	}
+	if nil != m.rateLimit && (m.rateLimit.Key == RateLimitKeyAccount) == authorized {
+		if m.applyRateLimit(ctx, "*", m.rateLimit) {
+			return true
+		}
+	}
+	if nil != handler.RateLimit && (handler.RateLimit.Key == RateLimitKeyAccount) == authorized {
+		return m.applyRateLimit(ctx, ctx.Request.Method+" "+ctx.FullPath(), handler.RateLimit)
+	}
+	return false
+}
+
+func (m *HttpServer) applyRateLimit(ctx *Context, route string, limit *RateLimit) bool {
+	var subject string
+	switch limit.Key {
+	case RateLimitKeyAccount:
+		if nil != ctx.Authorized && ctx.Authorized.ID != "" {
+			subject = "account:" + ctx.Authorized.ID.String()
+		}
+	case RateLimitKeyClient:
+		if ctx.ClientID != "" {
+			subject = "client:" + ctx.ClientID
+		}
+	}
+	if subject == "" {
+		subject = "ip:" + m.ClientIP(ctx)
+	}
+
+	result, err := m.rateLimiter.Allow(ctx.Request.Context(), route+":"+subject, limit)
+	if err != nil {
+		m.logger.Warn("rate limiter unavailable, request allowed", "route", route, "err", err)
+		return false
+	}
+
+	ctx.Writer.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	ctx.Writer.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	ctx.Writer.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+	if result.Allowed {
+		return false
+	}
+
+	ctx.Writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, &Response{
+		Code:      http.StatusTooManyRequests,
+		Message:   "too many requests",
+		Timestamp: time.Now().Local().Unix(),
+	})
+	return true
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	for _, algorithm := range []RateLimitAlgorithm{RateLimitTokenBucket, RateLimitSlidingWindow} {
+		limit := &RateLimit{Algorithm: algorithm, Limit: 3, Window: time.Hour}
+		for i := 0; i < 3; i++ {
+			result, err := limiter.Allow(context.Background(), string(algorithm), limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed {
+				t.Fatalf("%s: request %d should be allowed", algorithm, i)
+			}
+			if result.Remaining != 2-i {
+				t.Fatalf("%s: expected %d remaining, got %d", algorithm, 2-i, result.Remaining)
+			}
+		}
+		result, _ := limiter.Allow(context.Background(), string(algorithm), limit)
+		if result.Allowed {
+			t.Fatalf("%s: request over the limit should be rejected", algorithm)
+		}
+		if result.RetryAfter <= 0 {
+			t.Fatalf("%s: expected a retry after", algorithm)
+		}
+	}
+}
+
+func TestRateLimitedClientIP(t *testing.T) {
+	srv := newTestServer(t, &authorities.Settings{}, nil, "--http.trusted_proxy", "10.0.0.0/8")
+	if err := srv.SetRateLimiter(NewMemoryRateLimiter(), &RateLimit{Limit: 1}); err == nil {
+		t.Fatal("expected a limit without window to be rejected")
+	}
+	srv.SetRateLimiter(NewMemoryRateLimiter(), nil)
+	srv.Get("/limited", &Handler{
+		Policy:    authorities.AuthorizationPolicyAllow,
+		RateLimit: &RateLimit{Limit: 1, Window: time.Hour},
+		Func: func(ctx *Context) error {
+			ctx.WriteData("ok")
+			return nil
+		},
+	})
+	send := func(remote string, forwarded string) int {
+		request := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		request.RemoteAddr = remote
+		if forwarded != "" {
+			request.Header.Set("X-Forwarded-For", forwarded)
+		}
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if send("203.0.113.1:1000", "") != http.StatusOK {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if code := send("203.0.113.1:1001", "198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected a new connection with a spoofed X-Forwarded-For to share the bucket, got %d", code)
+	}
+	// through the proxy the clients are told apart by the address it forwards, not the one they send
+	if send("10.0.0.1:1000", "198.51.100.7, 203.0.113.2") != http.StatusOK {
+		t.Fatal("expected the forwarded client to have its own bucket")
+	}
+	if code := send("10.0.0.2:1000", "198.51.100.8, 203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the spoofed hop to be ignored, got %d", code)
+	}
+
+	srv.Get("/invalid", &Handler{RateLimit: &RateLimit{Limit: 1}, Func: func(ctx *Context) error { return nil }})
+	if err := srv.Startup(); err == nil {
+		t.Fatal("expected the route without window to fail the startup")
+	}
+}
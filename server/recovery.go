@@ -0,0 +1,216 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/utils"
+	"github.com/deepissue/core/websocket"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+const defaultCrashReports = 50
+
+const (
+	CrashSourceHTTP      = "http"
+	CrashSourceWebsocket = "websocket"
+)
+
+// CrashReport describes a recovered panic.
+type CrashReport struct {
+	Time    time.Time          `json:"time"`
+	Source  string             `json:"source"`
+	TraceID string             `json:"trace_id,omitempty"`
+	Method  string             `json:"method,omitempty"`
+	Route   string             `json:"route,omitempty"`
+	Path    string             `json:"path,omitempty"`
+	Remote  string             `json:"remote,omitempty"`
+	Account string             `json:"account,omitempty"`
+	Panic   string             `json:"panic"`
+	Stack   []utils.StackFrame `json:"stack"`
+}
+
+// CrashReports keeps the last panics recovered, served by the internal route /internal/crashes.
+type CrashReports struct {
+	mutex   sync.Mutex
+	size    int
+	reports []*CrashReport
+	next    int
+	total   int
+}
+
+func NewCrashReports(size int) *CrashReports {
+	if size <= 0 {
+		size = defaultCrashReports
+	}
+	return &CrashReports{size: size, reports: make([]*CrashReport, 0, size)}
+}
+
+// Add stores the report, replacing the oldest one when full.
+func (m *CrashReports) Add(report *CrashReport) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.total++
+	if len(m.reports) < m.size {
+		m.reports = append(m.reports, report)
+		return
+	}
+	m.reports[m.next] = report
+	m.next = (m.next + 1) % m.size
+}
+
+// Reports returns the reports kept, the latest first, and the number of panics since the start.
+func (m *CrashReports) Reports() ([]*CrashReport, int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reports := make([]*CrashReport, 0, len(m.reports))
+	for i := len(m.reports) - 1; i >= 0; i-- {
+		reports = append(reports, m.reports[(m.next+i)%len(m.reports)])
+	}
+	return reports, m.total
+}
+
+type crashReportsReply struct {
+	Total   int            `json:"total"`
+	Reports []*CrashReport `json:"reports"`
+}
+
+// Recovery turns the panics of the handlers into a 500 Response carrying the request id,
+// the panic is logged with its stack and kept in the crash reports, which may be nil.
+func Recovery(logger hclog.Logger, crashes *CrashReports) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			stack := utils.PanicStack()
+			if panicked, ok := r.(*handlerPanic); ok {
+				// the handler panicked in the goroutine of its timeout
+				r, stack = panicked.value, panicked.stack
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			report := &CrashReport{
+				Time:    time.Now(),
+				Source:  CrashSourceHTTP,
+				TraceID: c.GetString(requestIDContextKey),
+				Method:  c.Request.Method,
+				Route:   c.FullPath(),
+				Path:    requestPath(c.Request),
+				Remote:  c.ClientIP(),
+				Panic:   fmt.Sprint(r),
+				Stack:   stack,
+			}
+			if value, ok := c.Get(authorizedContextKey); ok {
+				if authorized, ok := value.(*authorities.Authorized); ok && nil != authorized {
+					report.Account = authorized.ID.String()
+				}
+			}
+			crashed(logger, crashes, report)
+
+			if brokenPipe(r) {
+				c.Abort()
+				return
+			}
+			if c.Writer.Written() {
+				// the response is partly written, the connection is the only thing left to end
+				c.Abort()
+				return
+			}
+			ctx := NewContext(c)
+			ctx.Negotiate(http.StatusInternalServerError, &Response{
+				Code:      http.StatusInternalServerError,
+				Message:   "internal server error",
+				Timestamp: time.Now().Local().Unix(),
+			})
+		}()
+		c.Next()
+	}
+}
+
+// websocketRecovery reports the panics of a websocket connection, they are logged by the connection.
+func (m *HttpServer) websocketRecovery(ctx *Context) websocket.PanicHandler {
+	return func(conn *websocket.WSConnection, r any, stack []utils.StackFrame) {
+		report := &CrashReport{
+			Time:    time.Now(),
+			Source:  CrashSourceWebsocket,
+			TraceID: ctx.RequestID(),
+			Method:  ctx.Request.Method,
+			Route:   ctx.FullPath(),
+			Path:    requestPath(ctx.Request),
+			Remote:  conn.RemoteAddr(),
+			Panic:   fmt.Sprint(r),
+			Stack:   stack,
+		}
+		if nil != conn.Authorized() {
+			report.Account = conn.Authorized().ID.String()
+		}
+		m.crashes.Add(report)
+	}
+}
+
+func crashed(logger hclog.Logger, crashes *CrashReports, report *CrashReport) {
+	frames := make([]string, 0, len(report.Stack))
+	for _, frame := range report.Stack {
+		frames = append(frames, frame.String())
+	}
+	logger.Error("panic recovered",
+		"source", report.Source,
+		"trace_id", report.TraceID,
+		"method", report.Method,
+		"route", report.Route,
+		"path", report.Path,
+		"remote", report.Remote,
+		"panic", report.Panic,
+		"stack", frames,
+	)
+	if nil != crashes {
+		crashes.Add(report)
+	}
+}
+
+// crashReports serves the crash reports on the internal route, which isn't registered without an internal secret
+// as the reports hold the panic values and the stacks.
+func (m *HttpServer) crashReports() {
+	if m.authorization.Settings().InternalSecret == "" {
+		m.logger.Debug("crash reports route disabled, no internal secret configured")
+		return
+	}
+	m.Internal(http.MethodGet, "crashes", &Handler{
+		Name:  "crash reports",
+		Tags:  []string{"internal"},
+		Reply: &crashReportsReply{},
+		Func: func(ctx *Context) error {
+			reports, total := m.crashes.Reports()
+			ctx.WriteData(&crashReportsReply{Total: total, Reports: reports})
+			return nil
+		},
+	})
+}
+
+// brokenPipe reports whether the panic comes from a client gone away, which needs no response.
+func brokenPipe(r any) bool {
+	err, ok := r.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var syscallErr *os.SyscallError
+	if errors.As(opErr, &syscallErr) {
+		return errors.Is(syscallErr.Err, syscall.EPIPE) || errors.Is(syscallErr.Err, syscall.ECONNRESET)
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestRecovery(t *testing.T) {
+	crashes := NewCrashReports(2)
+	engine := gin.New()
+	engine.Use(AccessLogger(hclog.NewNullLogger(), &option.Access{}))
+	engine.Use(Recovery(hclog.NewNullLogger(), crashes))
+	engine.GET("/orders/:id", func(c *gin.Context) {
+		panic("boom " + c.Param("id"))
+	})
+
+	for _, id := range []string{"1", "2", "3"} {
+		request := httptest.NewRequest(http.MethodGet, "/orders/"+id, nil)
+		request.Header.Set(RequestIDKey, "request-"+id)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500, got %d", recorder.Code)
+		}
+		response := &Response{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != http.StatusInternalServerError || response.TraceID != "request-"+id {
+			t.Fatalf("unexpected response %s", recorder.Body.String())
+		}
+	}
+
+	reports, total := crashes.Reports()
+	if total != 3 || len(reports) != 2 {
+		t.Fatalf("expected the last 2 of 3 reports, got %d of %d", len(reports), total)
+	}
+	report := reports[0]
+	if report.Panic != "boom 3" || report.TraceID != "request-3" || report.Route != "/orders/:id" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.Stack) == 0 || !strings.Contains(report.Stack[0].Function, "TestRecovery") {
+		t.Fatalf("expected the stack to start at the panic, got %v", report.Stack)
+	}
+}
+
+func TestCrashReportsSecret(t *testing.T) {
+	code := func(recorder *httptest.ResponseRecorder) int {
+		var response Response
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			return recorder.Code
+		}
+		return response.Code
+	}
+	srv := newTestServer(t, &authorities.Settings{InternalSecret: "secret"}, nil)
+	for _, header := range [][]string{nil, {InternalSecretKey, "wrong"}, {InternalSecretKey, ""}} {
+		if recorder := serve(srv, http.MethodGet, "/internal/crashes", header...); code(recorder) != http.StatusUnauthorized {
+			t.Fatalf("expected the crash reports refused with %v, got %s", header, recorder.Body.String())
+		}
+	}
+	if recorder := serve(srv, http.MethodGet, "/internal/crashes", InternalSecretKey, "secret"); code(recorder) != 0 {
+		t.Fatalf("expected the crash reports with the secret, got %s", recorder.Body.String())
+	}
+
+	srv = newTestServer(t, &authorities.Settings{}, nil)
+	srv.Internal(http.MethodGet, "/sync", &Handler{Func: func(ctx *Context) error {
+		ctx.WriteData("synced")
+		return nil
+	}})
+	if recorder := serve(srv, http.MethodGet, "/internal/crashes"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected no crash reports route without a secret, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve(srv, http.MethodGet, "/internal/sync", InternalSecretKey, ""); code(recorder) != http.StatusUnauthorized {
+		t.Fatalf("expected the internal routes refused without a secret configured, got %s", recorder.Body.String())
+	}
+}
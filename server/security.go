@@ -0,0 +1,420 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+)
+
+// The sources of the Content-Security-Policy directives.
+const (
+	CSPSelf          = "'self'"
+	CSPNone          = "'none'"
+	CSPUnsafeInline  = "'unsafe-inline'"
+	CSPStrictDynamic = "'strict-dynamic'"
+	CSPData          = "data:"
+	CSPBlob          = "blob:"
+)
+
+const (
+	cspNonceContextKey = "core.csp_nonce"
+	cspReportEndpoint  = "csp-endpoint"
+	// maxCSPReport bounds the body of a violation report
+	maxCSPReport = 64 << 10
+)
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// ContentSecurityPolicy builds a Content-Security-Policy, the directives are written in
+// the order they were added. With Nonce, a nonce generated for each request is added to
+// script-src and style-src, see Context.Nonce.
+type ContentSecurityPolicy struct {
+	directives []*cspDirective
+	nonce      bool
+	reportOnly bool
+	reportURI  string
+}
+
+func NewContentSecurityPolicy() *ContentSecurityPolicy {
+	return &ContentSecurityPolicy{}
+}
+
+// DefaultContentSecurityPolicy allows the resources of the same origin only and forbids the framing.
+func DefaultContentSecurityPolicy() *ContentSecurityPolicy {
+	return NewContentSecurityPolicy().
+		DefaultSrc(CSPSelf).
+		ObjectSrc(CSPNone).
+		BaseURI(CSPSelf).
+		FormAction(CSPSelf).
+		FrameAncestors(CSPNone)
+}
+
+// ParseContentSecurityPolicy parses directives such as "script-src 'self' https://cdn.example.com".
+func ParseContentSecurityPolicy(directives []string) (*ContentSecurityPolicy, error) {
+	policy := NewContentSecurityPolicy()
+	for _, directive := range directives {
+		for _, directive := range strings.Split(directive, ";") {
+			fields := strings.Fields(directive)
+			if len(fields) == 0 {
+				continue
+			}
+			name := strings.ToLower(fields[0])
+			if strings.Trim(name, "abcdefghijklmnopqrstuvwxyz-") != "" {
+				return nil, fmt.Errorf("csp: invalid directive %s", fields[0])
+			}
+			policy.Directive(name, fields[1:]...)
+		}
+	}
+	if len(policy.directives) == 0 {
+		return nil, errors.New("csp: no directive")
+	}
+	return policy, nil
+}
+
+// Directive adds the sources to the directive.
+func (p *ContentSecurityPolicy) Directive(name string, sources ...string) *ContentSecurityPolicy {
+	for _, directive := range p.directives {
+		if directive.name == name {
+			for _, source := range sources {
+				if !slices.Contains(directive.sources, source) {
+					directive.sources = append(directive.sources, source)
+				}
+			}
+			return p
+		}
+	}
+	p.directives = append(p.directives, &cspDirective{name: name, sources: slices.Clone(sources)})
+	return p
+}
+
+func (p *ContentSecurityPolicy) DefaultSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("default-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) ScriptSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("script-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) StyleSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("style-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) ImgSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("img-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) ConnectSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("connect-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) FontSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("font-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) FrameSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("frame-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) ObjectSrc(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("object-src", sources...)
+}
+
+func (p *ContentSecurityPolicy) BaseURI(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("base-uri", sources...)
+}
+
+func (p *ContentSecurityPolicy) FormAction(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("form-action", sources...)
+}
+
+func (p *ContentSecurityPolicy) FrameAncestors(sources ...string) *ContentSecurityPolicy {
+	return p.Directive("frame-ancestors", sources...)
+}
+
+// Nonce adds the nonce of the request to script-src and style-src, which get the
+// sources of default-src when they are not set.
+func (p *ContentSecurityPolicy) Nonce() *ContentSecurityPolicy {
+	p.nonce = true
+	return p
+}
+
+// ReportOnly sends the policy in Content-Security-Policy-Report-Only, the violations are reported only.
+func (p *ContentSecurityPolicy) ReportOnly() *ContentSecurityPolicy {
+	p.reportOnly = true
+	return p
+}
+
+// ReportTo sends the violation reports to the uri, such as the /csp-report collector of the server.
+func (p *ContentSecurityPolicy) ReportTo(uri string) *ContentSecurityPolicy {
+	p.reportURI = uri
+	return p
+}
+
+// header returns the name of the header of the policy.
+func (p *ContentSecurityPolicy) header() string {
+	if p.reportOnly {
+		return "Content-Security-Policy-Report-Only"
+	}
+	return "Content-Security-Policy"
+}
+
+// render returns the policy with the nonce of the request.
+func (p *ContentSecurityPolicy) render(nonce string) string {
+	var defaults []string
+	for _, directive := range p.directives {
+		if directive.name == "default-src" {
+			defaults = directive.sources
+		}
+	}
+	var builder strings.Builder
+	write := func(name string, sources []string) {
+		if builder.Len() > 0 {
+			builder.WriteString("; ")
+		}
+		builder.WriteString(name)
+		for _, source := range sources {
+			builder.WriteString(" ")
+			builder.WriteString(source)
+		}
+	}
+	nonced := map[string]bool{}
+	for _, directive := range p.directives {
+		sources := directive.sources
+		if p.nonce && nonce != "" && (directive.name == "script-src" || directive.name == "style-src") {
+			sources = nonceSources(sources, nonce)
+			nonced[directive.name] = true
+		}
+		write(directive.name, sources)
+	}
+	if p.nonce && nonce != "" {
+		for _, name := range []string{"script-src", "style-src"} {
+			if !nonced[name] {
+				write(name, nonceSources(defaults, nonce))
+			}
+		}
+	}
+	if p.reportURI != "" {
+		write("report-uri", []string{p.reportURI})
+		write("report-to", []string{cspReportEndpoint})
+	}
+	return builder.String()
+}
+
+// nonceSources adds the nonce to the sources, 'none' can't be combined with other sources.
+func nonceSources(sources []string, nonce string) []string {
+	sources = slices.DeleteFunc(slices.Clone(sources), func(source string) bool {
+		return source == CSPNone
+	})
+	return append(sources, "'nonce-"+nonce+"'")
+}
+
+func (p *ContentSecurityPolicy) String() string {
+	return p.render("")
+}
+
+// SecurityHeaders are set on the responses by the Secure middleware, the empty ones are removed
+// so that the headers of a route group replace the ones of the server.
+type SecurityHeaders struct {
+	// HSTS is the max-age of Strict-Transport-Security, sent on HTTPS requests only
+	HSTS              time.Duration
+	HSTSSubdomains    bool
+	HSTSPreload       bool
+	FrameOptions      string
+	ReferrerPolicy    string
+	PermissionsPolicy string
+	CSP               *ContentSecurityPolicy
+}
+
+// NewSecurityHeaders returns the headers of the settings, the violations are reported to
+// reportURI when the report collector is enabled.
+func NewSecurityHeaders(opts *option.Security, reportURI string) (*SecurityHeaders, error) {
+	frameOptions := strings.ToUpper(opts.FrameOptions)
+	if frameOptions != "" && frameOptions != "DENY" && frameOptions != "SAMEORIGIN" {
+		return nil, fmt.Errorf("invalid http.security.frame_options %s", opts.FrameOptions)
+	}
+	csp := DefaultContentSecurityPolicy()
+	if len(opts.CSP) > 0 {
+		policy, err := ParseContentSecurityPolicy(opts.CSP)
+		if err != nil {
+			return nil, err
+		}
+		csp = policy
+	}
+	if opts.CSPNonce {
+		csp.Nonce()
+	}
+	if opts.CSPReportOnly {
+		csp.ReportOnly()
+	}
+	if opts.CSPReport {
+		csp.ReportTo(reportURI)
+	}
+	return &SecurityHeaders{
+		HSTS:              time.Duration(opts.HSTS) * time.Second,
+		HSTSSubdomains:    opts.HSTSSubdomains,
+		HSTSPreload:       opts.HSTSPreload,
+		FrameOptions:      frameOptions,
+		ReferrerPolicy:    opts.ReferrerPolicy,
+		PermissionsPolicy: opts.PermissionsPolicy,
+		CSP:               csp,
+	}, nil
+}
+
+// Secure returns a middleware setting the security headers, X-Content-Type-Options is always nosniff.
+func Secure(headers *SecurityHeaders) gin.HandlerFunc {
+	hsts := ""
+	if headers.HSTS > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(headers.HSTS.Seconds()))
+		if headers.HSTSSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if headers.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		set := func(name string, value string) {
+			if value == "" {
+				header.Del(name)
+			} else {
+				header.Set(name, value)
+			}
+		}
+		if https(c.Request) {
+			set("Strict-Transport-Security", hsts)
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+		set("X-Frame-Options", headers.FrameOptions)
+		set("Referrer-Policy", headers.ReferrerPolicy)
+		set("Permissions-Policy", headers.PermissionsPolicy)
+
+		header.Del("Content-Security-Policy")
+		header.Del("Content-Security-Policy-Report-Only")
+		if csp := headers.CSP; nil != csp {
+			nonce := c.GetString(cspNonceContextKey)
+			if csp.nonce && nonce == "" {
+				nonce = newNonce()
+				c.Set(cspNonceContextKey, nonce)
+			}
+			header.Set(csp.header(), csp.render(nonce))
+			if csp.reportURI != "" {
+				header.Set("Reporting-Endpoints", cspReportEndpoint+`="`+csp.reportURI+`"`)
+			}
+		}
+		c.Next()
+	}
+}
+
+// Nonce returns the nonce of the Content-Security-Policy of the request, to be set on the
+// nonce attribute of the inline scripts and styles. It is empty when the policy has no nonce.
+func (c *Context) Nonce() string {
+	return c.GetString(cspNonceContextKey)
+}
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(nonce)
+}
+
+// https reports whether the request was received over TLS, by the server or a proxy in front of it.
+func https(r *http.Request) bool {
+	return nil != r.TLS || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// cspReport collects the violation reports sent by the browsers, in the report-uri format
+// or the Reporting API one, and logs them.
+func (m *HttpServer) cspReport() {
+	m.Post("csp-report", &Handler{
+		Name:   "csp report",
+		Tags:   []string{"security"},
+		Policy: authorities.AuthorizationPolicyAllow,
+		// the browsers send the reports with the cookies of the page and no token
+		CSRFExempt: true,
+		Func: func(ctx *Context) error {
+			body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxCSPReport))
+			if err != nil {
+				return err
+			}
+			for _, report := range parseCSPReports(body) {
+				m.logger.Warn("csp violation",
+					"document", report["document-uri"],
+					"directive", report["effective-directive"],
+					"blocked", report["blocked-uri"],
+					"source", report["source-file"],
+					"line", report["line-number"],
+					"disposition", report["disposition"],
+					"client_ip", ctx.ClientIP(),
+					"user_agent", ctx.Request.UserAgent(),
+				)
+			}
+			ctx.Status(http.StatusNoContent)
+			return nil
+		},
+	})
+}
+
+// parseCSPReports returns the reports with the names of the report-uri format.
+func parseCSPReports(body []byte) []map[string]any {
+	var legacy struct {
+		Report map[string]any `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &legacy); err == nil && nil != legacy.Report {
+		if _, ok := legacy.Report["effective-directive"]; !ok {
+			legacy.Report["effective-directive"] = legacy.Report["violated-directive"]
+		}
+		return []map[string]any{legacy.Report}
+	}
+
+	var reports []struct {
+		Type string         `json:"type"`
+		Body map[string]any `json:"body"`
+	}
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return nil
+	}
+	// the Reporting API names the fields in camel case
+	names := map[string]string{
+		"documentURL":        "document-uri",
+		"effectiveDirective": "effective-directive",
+		"blockedURL":         "blocked-uri",
+		"sourceFile":         "source-file",
+		"lineNumber":         "line-number",
+		"disposition":        "disposition",
+	}
+	var parsed []map[string]any
+	for _, report := range reports {
+		if report.Type != "csp-violation" || nil == report.Body {
+			continue
+		}
+		fields := make(map[string]any, len(names))
+		for name, legacyName := range names {
+			fields[legacyName] = report.Body[name]
+		}
+		parsed = append(parsed, fields)
+	}
+	return parsed
+}
+
+// cspReportURI is the path of the report collector.
+func cspReportURI(base string) string {
+	uri, _ := url.JoinPath("/", base, "csp-report")
+	return uri
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestContentSecurityPolicy(t *testing.T) {
+	policy := NewContentSecurityPolicy().
+		DefaultSrc(CSPSelf).
+		ImgSrc(CSPSelf, CSPData).
+		ObjectSrc(CSPNone).
+		Nonce().
+		ReportTo("/csp-report")
+	expected := "default-src 'self'; img-src 'self' data:; object-src 'none'; " +
+		"script-src 'self' 'nonce-abc'; style-src 'self' 'nonce-abc'; report-uri /csp-report; report-to csp-endpoint"
+	if rendered := policy.render("abc"); rendered != expected {
+		t.Fatalf("unexpected policy %s", rendered)
+	}
+
+	parsed, err := ParseContentSecurityPolicy([]string{"default-src 'self'; script-src 'none'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered := parsed.Nonce().render("abc"); !strings.Contains(rendered, "script-src 'nonce-abc'") {
+		t.Fatalf("expected 'none' to be replaced by the nonce in %s", rendered)
+	}
+	if _, err := ParseContentSecurityPolicy([]string{"script_src 'self'"}); err == nil {
+		t.Fatal("expected the directive to be refused")
+	}
+}
+
+func TestSecure(t *testing.T) {
+	engine := gin.New()
+	engine.Use(Secure(&SecurityHeaders{
+		HSTS:           time.Hour,
+		FrameOptions:   "DENY",
+		ReferrerPolicy: "no-referrer",
+		CSP:            DefaultContentSecurityPolicy().Nonce(),
+	}))
+	nonce := ""
+	engine.GET("/page", func(c *gin.Context) {
+		nonce = NewContext(c).Nonce()
+	})
+	embedded := engine.Group("/embedded", Secure(&SecurityHeaders{
+		CSP: NewContentSecurityPolicy().FrameAncestors("https://portal.example.com").ReportOnly(),
+	}))
+	embedded.GET("/widget", func(c *gin.Context) {})
+
+	request := httptest.NewRequest(http.MethodGet, "https://example.com/page", nil)
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	header := recorder.Header()
+	if header.Get("Strict-Transport-Security") != "max-age=3600" || header.Get("X-Content-Type-Options") != "nosniff" ||
+		header.Get("X-Frame-Options") != "DENY" || header.Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+	if nonce == "" || !strings.Contains(header.Get("Content-Security-Policy"), "'nonce-"+nonce+"'") {
+		t.Fatalf("expected the nonce %q in %s", nonce, header.Get("Content-Security-Policy"))
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "http://example.com/embedded/widget", nil)
+	recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, request)
+	header = recorder.Header()
+	if header.Get("X-Frame-Options") != "" || header.Get("Content-Security-Policy") != "" ||
+		header.Get("Content-Security-Policy-Report-Only") != "frame-ancestors https://portal.example.com" {
+		t.Fatalf("expected the headers of the group, got %v", header)
+	}
+	if header.Get("Strict-Transport-Security") != "" {
+		t.Fatal("expected no HSTS over http")
+	}
+}
+
+func TestParseCSPReports(t *testing.T) {
+	legacy := parseCSPReports([]byte(`{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src","blocked-uri":"inline"}}`))
+	if len(legacy) != 1 || legacy[0]["effective-directive"] != "script-src" {
+		t.Fatalf("unexpected reports %v", legacy)
+	}
+	reports := parseCSPReports([]byte(`[{"type":"csp-violation","body":{"documentURL":"https://example.com/","effectiveDirective":"img-src","blockedURL":"https://evil.com/a.png"}},{"type":"deprecation","body":{}}]`))
+	if len(reports) != 1 || reports[0]["blocked-uri"] != "https://evil.com/a.png" {
+		t.Fatalf("unexpected reports %v", reports)
+	}
+}
@@ -8,41 +8,168 @@ package server
 
 import (
 	"context"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/deepissue/core/logging"
 	"github.com/deepissue/core/option"
 	"github.com/deepissue/core/utils"
+	"github.com/deepissue/core/websocket"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/gobwas/ws"
 )
 
+type ShutdownHook func(ctx context.Context) error
+
+type shutdownHook struct {
+	name string
+	hook ShutdownHook
+}
+
 type Server struct {
-	Ctx    context.Context
-	opts   *option.Options
-	cancel context.CancelFunc
-	logger *logging.Logger
-	doneCh chan struct{}
+	Ctx         context.Context
+	opts        *option.Options
+	cancel      context.CancelFunc
+	logger      *logging.Logger
+	doneCh      chan struct{}
+	forceCh     chan struct{}
+	health      *Health
+	websockets  *websocket.Registry
+	crashes     *CrashReports
+	httpServers []*HttpServer
+	hooks       []*shutdownHook
+	lifecycle   *Lifecycle
+	mutex       sync.Mutex
 }
 
 func NewServer(opts *option.Options, logger *logging.Logger) (*Server, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
+	doneCh, forceCh := utils.MakeGracefulShutdownCh()
 	srv := &Server{
-		Ctx:    ctx,
-		opts:   opts,
-		cancel: cancel,
-		logger: logger,
-		doneCh: utils.MakeShutdownCh(),
+		Ctx:        ctx,
+		opts:       opts,
+		cancel:     cancel,
+		logger:     logger,
+		doneCh:     doneCh,
+		forceCh:    forceCh,
+		health:     NewHealth(),
+		websockets: websocket.NewRegistry(),
+		crashes:    NewCrashReports(defaultCrashReports),
+		lifecycle:  NewLifecycle(logger),
 	}
 	return srv, nil
 }
 
+// Register adds a component started by Run after its dependencies
+// and stopped in reverse order on shutdown.
+func (m *Server) Register(name string, component Component, dependencies ...string) error {
+	return m.lifecycle.Register(name, component, dependencies...)
+}
+
+// Run starts the registered components and blocks until the server is shut down.
+// When a component fails to start, the started ones are stopped and the error is returned.
+func (m *Server) Run() error {
+	if err := m.lifecycle.Start(m.Ctx); err != nil {
+		m.cancel()
+		return err
+	}
+	m.HandleSignal(nil)
+	return nil
+}
+
+// Health returns the health registry shared with the http servers.
+func (m *Server) Health() *Health {
+	return m.health
+}
+
+// Websockets returns the registry of websocket connections closed on shutdown.
+func (m *Server) Websockets() *websocket.Registry {
+	return m.websockets
+}
+
+// CrashReports returns the last panics recovered by the http servers and the websocket connections.
+func (m *Server) CrashReports() *CrashReports {
+	return m.crashes
+}
+
+// OnShutdown registers a hook, hooks run in reverse order of registration on shutdown.
+func (m *Server) OnShutdown(name string, hook ShutdownHook) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, &shutdownHook{name: name, hook: hook})
+}
+
+// HandleSignal blocks until SIGINT or SIGTERM and then shuts down in order:
+// mark not-ready, drain the http servers, close the websockets, stop the components
+// including the http servers registered as components,
+// run onStop and the shutdown hooks in reverse order, and finally close the loggers.
+// A second signal forces the process to exit.
 func (m *Server) HandleSignal(onStop func()) {
 	<-m.doneCh
-	onStop()
-	m.cancel()
-	time.Sleep(time.Second)
 	m.logger.Info("server shutting...")
+	go func() {
+		select {
+		case <-m.forceCh:
+			m.logger.Warn("received second signal, forcing exit")
+			m.logger.Cleanup()
+			os.Exit(1)
+		case <-m.Ctx.Done():
+		}
+	}()
+
+	m.Shutdown(onStop)
 	m.logger.Info("server shutdown completed")
 	m.logger.Cleanup()
 }
+
+// Shutdown stops the server, it is bounded by option.Http.ShutdownTimeout.
+func (m *Server) Shutdown(onStop func()) {
+	timeout := time.Duration(m.opts.Http.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	m.health.SetShutting()
+	m.logger.Info("marked server as not ready")
+
+	m.mutex.Lock()
+	httpServers := m.httpServers
+	hooks := m.hooks
+	m.mutex.Unlock()
+
+	for _, srv := range httpServers {
+		if srv.managed {
+			continue
+		}
+		m.logger.Info("draining http server", "addr", srv.addr)
+		if err := srv.Shutdown(ctx); err != nil {
+			m.logger.Error("drain http server", "addr", srv.addr, "err", err)
+			continue
+		}
+		m.logger.Info("http server drained", "addr", srv.addr)
+	}
+
+	m.logger.Info("closing websocket connections", "count", m.websockets.Len())
+	m.websockets.CloseAll(ws.StatusGoingAway, "server shutting down")
+
+	if err := m.lifecycle.Stop(ctx); err != nil {
+		m.logger.Error("stop components", "err", err)
+	}
+
+	if onStop != nil {
+		onStop()
+	}
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		m.logger.Info("running shutdown hook", "name", hooks[i].name)
+		if err := hooks[i].hook(ctx); err != nil {
+			m.logger.Error("shutdown hook", "name", hooks[i].name, "err", err)
+		}
+	}
+
+	m.cancel()
+}
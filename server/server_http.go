@@ -15,8 +15,7 @@ import (
 	"time"
 
 	"github.com/deepissue/core/authorities"
-	"github.com/deepissue/core/option"
-	"github.com/gin-contrib/cors"
+	"github.com/deepissue/core/websocket"
 	"github.com/gin-gonic/gin"
 	"github.com/hashicorp/go-hclog"
 	"github.com/swaggest/openapi-go/openapi3"
@@ -26,12 +25,49 @@ type HttpServer struct {
 	ctx           context.Context
 	addr          string
 	path          string
+	profile       string
 	ln            net.Listener
 	logger        hclog.Logger
 	engine        *gin.Engine
 	httpServer    *http.Server
 	authorization authorities.Authorization
 	reflector     *openapi3.Reflector
+	// internalReflector documents the internal routes apart from the public spec
+	internalReflector *openapi3.Reflector
+	health            *Health
+	websockets        *websocket.Registry
+	timeout           time.Duration
+	// handlerTimeout is the execution timeout of the routes without Handler.Timeout
+	handlerTimeout time.Duration
+	// proxies give the address of the clients, see ClientIP
+	proxies trustedProxies
+	// invalidRoutes are the routes rejected at registration, Startup fails with them
+	invalidRoutes error
+	// managed is set once the server is a Component, the lifecycle drains it instead of Server.Shutdown
+	managed      bool
+	certificates *certificateReloader
+	reload       time.Duration
+	rateLimiter  RateLimiter
+	rateLimit    *RateLimit
+	idempotency  IdempotencyStore
+	crashes      *CrashReports
+	// corsPolicy is nil when CORS is disabled, corsRoutes are the overrides by method and route
+	corsPolicy    *CorsPolicy
+	corsRoutes    map[string]*CorsPolicy
+	corsTemplates []*routePolicy
+	// csrf is nil when the CSRF protection is disabled
+	csrf *csrfProtection
+	// sessions is nil when the session cookies are disabled
+	sessions *sessionCookies
+	// pagination holds the page sizes and the key of the cursors
+	pagination *pagination
+	// downloadSigner signs the URLs of the Downloads
+	downloadSigner *urlSigner
+	cache          CacheStore
+	cacheFlights   cacheFlights
+
+	versions       map[string]*APIVersion
+	defaultVersion string
 }
 
 func (m *Server) NewHttpServer(authorization authorities.Authorization) (*HttpServer, error) {
@@ -40,13 +76,50 @@ func (m *Server) NewHttpServer(authorization authorities.Authorization) (*HttpSe
 	}
 
 	gin.DisableConsoleColor()
-	recover := gin.RecoveryWithWriter(m.logger.StandardWriter(&hclog.StandardLoggerOptions{}))
+	access := hclog.Logger(m.logger)
+	if m.opts.Http.Access.File != "" {
+		logger, err := m.logger.NewFileLogger(m.opts.Http.Access.File)
+		if err != nil {
+			return nil, err
+		}
+		access = logger
+	}
+
+	proxies, err := newTrustedProxies(m.opts.Http.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
 	engine := gin.New()
-	engine.Use(recover)
-	engine.Use(HclogMiddleware(m.logger))
+	// the access log and the crash reports get the same address as the rate limits
+	if err := engine.SetTrustedProxies(m.opts.Http.TrustedProxies); err != nil {
+		return nil, err
+	}
+	// the access log sees the bodies decompressed and the responses before compression
+	if m.opts.Http.Compress.Decompress {
+		engine.Use(Decompress(&m.opts.Http.Compress))
+	}
+	if m.opts.Http.Compress.Enabled {
+		engine.Use(Compress(&m.opts.Http.Compress))
+	}
+	engine.Use(AccessLogger(access, &m.opts.Http.Access))
+	// inside the access log and the compression so the error response is logged and compressed
+	engine.Use(Recovery(m.logger, m.crashes))
+	if m.opts.Http.Security.Enabled {
+		headers, err := NewSecurityHeaders(&m.opts.Http.Security, cspReportURI(m.opts.Http.Path))
+		if err != nil {
+			return nil, err
+		}
+		engine.Use(Secure(headers))
+	}
 	addr := fmt.Sprintf("%s:%d", m.opts.Http.Address, m.opts.Http.Port)
-	if m.opts.Http.Cors {
-		engine.Use(Cors(m.opts))
+
+	var corsPolicy *CorsPolicy
+	if m.opts.Http.Cors.Enabled {
+		policy, err := NewCorsPolicy(&m.opts.Http.Cors)
+		if err != nil {
+			return nil, err
+		}
+		corsPolicy = policy
 	}
 
 	httpServer := &http.Server{
@@ -61,23 +134,80 @@ func (m *Server) NewHttpServer(authorization authorities.Authorization) (*HttpSe
 		return nil, errors.New("the http.path must start with a /")
 	}
 
-	reflector := openapi3.NewReflector()
-	reflector.Spec = &openapi3.Spec{Openapi: "3.0.3"}
-	reflector.Spec.Info.
-		WithTitle(m.opts.Application + " API").WithDescription("")
+	var certificates *certificateReloader
+	if m.opts.Http.TLS.Enabled() {
+		reloader, err := newCertificateReloader(&m.opts.Http.TLS, m.logger)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := newTLSConfig(&m.opts.Http.TLS, reloader)
+		if err != nil {
+			return nil, err
+		}
+		httpServer.TLSConfig = tlsConfig
+		certificates = reloader
+	} else if m.opts.Http.H2C {
+		httpServer.Protocols = new(http.Protocols)
+		httpServer.Protocols.SetHTTP1(true)
+		httpServer.Protocols.SetUnencryptedHTTP2(true)
+	}
 
 	srv := &HttpServer{
-		ctx:           m.Ctx,
-		logger:        m.logger,
-		engine:        engine,
-		httpServer:    httpServer,
-		addr:          addr,
-		path:          m.opts.Http.Path,
-		authorization: authorization,
-		reflector:     reflector,
+		ctx:            m.Ctx,
+		logger:         m.logger,
+		engine:         engine,
+		httpServer:     httpServer,
+		addr:           addr,
+		path:           m.opts.Http.Path,
+		profile:        m.opts.Profile,
+		authorization:  authorization,
+		health:         m.health,
+		websockets:     m.websockets,
+		timeout:        time.Duration(m.opts.Http.ShutdownTimeout) * time.Second,
+		handlerTimeout: time.Duration(m.opts.Http.HandlerTimeout) * time.Second,
+		proxies:        proxies,
+		certificates:   certificates,
+		reload:         time.Duration(m.opts.Http.TLS.Reload) * time.Second,
+		versions:       make(map[string]*APIVersion),
+		idempotency:    NewMemoryIdempotencyStore(),
+		cache:          NewMemoryCacheStore(),
+		crashes:        m.crashes,
+		corsPolicy:     corsPolicy,
+		corsRoutes:     make(map[string]*CorsPolicy),
+		pagination:     newPagination(&m.opts.Http.Pagination),
+		downloadSigner: newURLSigner(&m.opts.Http.Download),
 	}
+	httpServer.Handler = srv
+	if m.opts.Http.Session.Enabled {
+		srv.sessions = newSessionCookies(&m.opts.Http.Session)
+	}
+	if m.opts.Http.Csrf.Enabled {
+		srv.csrf = newCSRFProtection(&m.opts.Http.Csrf, srv.sessions, m.logger)
+	}
+	engine.Use(srv.cors())
+	srv.reflector = srv.newReflector(m.opts.Application+" API", "1.0.0", false)
+	srv.internalReflector = srv.newReflector(m.opts.Application+" Internal API", "1.0.0", true)
 
-	srv.openapi("openapi.json")
+	if pinger, ok := authorization.TokenHandler().(interface{ Ping(context.Context) error }); ok {
+		m.health.Register(&HealthCheck{Name: "authorization", Checker: HealthCheckerFunc(pinger.Ping), Critical: true})
+	}
+
+	srv.openapi("openapi.json", srv.reflector)
+	srv.openapi("openapi.yaml", srv.reflector)
+	srv.openapi("internal/openapi.json", srv.internalReflector, true)
+	srv.openapi("internal/openapi.yaml", srv.internalReflector, true)
+	srv.healthz()
+	srv.crashReports()
+	if m.opts.Http.Security.Enabled && m.opts.Http.Security.CSPReport {
+		srv.cspReport()
+	}
+	if nil != srv.csrf {
+		srv.csrfToken()
+	}
+
+	m.mutex.Lock()
+	m.httpServers = append(m.httpServers, srv)
+	m.mutex.Unlock()
 	return srv, nil
 }
 
@@ -91,58 +221,60 @@ func (m *HttpServer) Use(middleware ...gin.HandlerFunc) *HttpServer {
 }
 
 func (m *HttpServer) Startup() error {
+	if m.invalidRoutes != nil {
+		return m.invalidRoutes
+	}
 
 	ln, err := net.Listen("tcp", m.addr)
 	if err != nil {
 		m.logger.Error("failed to listen on address", "addr", m.addr, "err", err)
 		return err
 	}
-	m.logger.Info("http server listened on", "addr", m.addr)
+	m.logger.Info("http server listened on", "addr", m.addr, "tls", m.certificates != nil)
 	m.ln = ln
-	go m.httpServer.Serve(m.ln)
 	go func() {
-		<-m.ctx.Done()
-		m.Stop()
-		m.ln.Close()
-		m.httpServer.Close()
+		var err error
+		if m.certificates != nil {
+			go m.certificates.watch(m.ctx, m.reload)
+			err = m.httpServer.ServeTLS(m.ln, "", "")
+		} else {
+			err = m.httpServer.Serve(m.ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.logger.Error("http server serve", "addr", m.addr, "err", err)
+		}
 	}()
 	return nil
 }
 
+// Component adapts the http server to a Component managed by Server.Register,
+// it is drained when the lifecycle stops rather than by Server.Shutdown.
+func (m *HttpServer) Component() Component {
+	m.managed = true
+	return NewComponent(func(ctx context.Context) error {
+		return m.Startup()
+	}, m.Shutdown)
+}
+
+// Stop drains the http server within option.Http.ShutdownTimeout.
 func (m *HttpServer) Stop() {
-	if err := m.httpServer.Shutdown(m.ctx); err != nil {
-		m.logger.Error("shutdown http server", "err", "err")
-		return
+	timeout := m.timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := m.Shutdown(ctx); err != nil {
+		m.logger.Error("shutdown http server", "err", err)
 	}
 }
 
-func Cors(opts *option.Options) gin.HandlerFunc {
-	headers := []string{
-		"Origin", "Content-Kind",
-		string(AuthorizationKey),
-		string(InternalSecretKey), string(ClientIDKey),
-		"Os-Version", "Application-Version", "Location", "Content-Disposition",
-	}
-
-	headers = append(headers, []string{
-		"Sec-WebSocket-Extensions",
-		"Sec-WebSocket-Version", "Sec-WebSocket-Key",
-		"Sec-WebSocket-Protocol", "Upgrade",
-	}...)
-
-	return cors.New(cors.Config{
-		//准许跨域请求网站,多个使用,分开,限制使用*
-		AllowAllOrigins: true,
-		//准许使用的请求方式
-		AllowMethods: []string{"PUT", "PATCH", "POST", "GET", "DELETE", "OPTIONS"},
-		//准许使用的请求表头
-		AllowHeaders: []string{"*"},
-		//显示的请求表头
-		ExposeHeaders: headers,
-		//凭证共享,确定共享
-		AllowCredentials: true,
-		//超时时间设定
-		MaxAge:                 24 * time.Hour,
-		AllowBrowserExtensions: true,
-	})
+// Shutdown stops accepting connections and waits for in-flight requests until ctx is done,
+// the remaining connections are closed when the deadline is exceeded.
+func (m *HttpServer) Shutdown(ctx context.Context) error {
+	if err := m.httpServer.Shutdown(ctx); err != nil {
+		m.httpServer.Close()
+		return err
+	}
+	return nil
 }
@@ -7,8 +7,68 @@
 package server
 
 import (
+	"net/http/httptest"
 	"testing"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/logging"
+	"github.com/deepissue/core/option"
+	"github.com/jessevdk/go-flags"
 )
 
 func TestNewServer(t *testing.T) {
 }
+
+// newTestCore builds a server from the command line flags, the options not given have their defaults.
+func newTestCore(t *testing.T, args ...string) *Server {
+	t.Helper()
+	var opts option.Options
+	args = append([]string{"--application", "test", "--profile", "test", "--config", "test", "--log.path", t.TempDir()}, args...)
+	if _, err := flags.NewParser(&opts, flags.None).ParseArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	logger, err := logging.NewLogger("test", &opts.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(logger.Cleanup)
+	s, err := NewServer(&opts, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newTestServer builds an http server from the command line flags, see newTestCore.
+// The tokens authenticate the requests, a simple token handler accepting "token" when nil.
+func newTestServer(t *testing.T, settings *authorities.Settings, tokens authorities.TokenHandler, args ...string) *HttpServer {
+	t.Helper()
+	return newTestHttpServer(t, newTestCore(t, args...), settings, tokens)
+}
+
+func newTestHttpServer(t *testing.T, s *Server, settings *authorities.Settings, tokens authorities.TokenHandler) *HttpServer {
+	t.Helper()
+	if nil == tokens {
+		tokens, _ = authorities.NewSimpleTokenHandler("token")
+	}
+	authorization, err := authorities.NewAuthorization(settings, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := s.NewHttpServer(authorization)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// serve sends the request to the server, the header values are set after the method and the target.
+func serve(srv *HttpServer, method string, target string, header ...string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		request.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	srv.ServeHTTP(recorder, request)
+	return recorder
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+)
+
+var errSessionsDisabled = errors.New("session cookies are disabled, see http.session")
+
+var sameSites = map[string]http.SameSite{
+	"strict": http.SameSiteStrictMode,
+	"lax":    http.SameSiteLaxMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// sessionCookies keeps the tokens of the browsers in an HttpOnly cookie, out of the reach of the scripts.
+// The value is the time the token was issued and the token, the token of an active session is rotated
+// once older than renew and the cookie gets a new lifetime.
+type sessionCookies struct {
+	name     string
+	domain   string
+	path     string
+	sameSite http.SameSite
+	insecure bool
+	ttl      time.Duration
+	renew    time.Duration
+	grace    time.Duration
+}
+
+type session struct {
+	token  string
+	issued time.Time
+}
+
+func newSessionCookies(opts *option.Session) *sessionCookies {
+	sessions := &sessionCookies{
+		name:     opts.Cookie,
+		domain:   opts.Domain,
+		path:     opts.Path,
+		sameSite: sameSites[strings.ToLower(opts.SameSite)],
+		insecure: opts.Insecure,
+		ttl:      time.Duration(opts.TTL) * time.Second,
+		renew:    time.Duration(opts.Renew) * time.Second,
+		grace:    time.Duration(opts.Grace) * time.Second,
+	}
+	if sessions.name == "" {
+		sessions.name = "session"
+	}
+	if sessions.path == "" {
+		sessions.path = "/"
+	}
+	if sessions.sameSite == 0 {
+		sessions.sameSite = http.SameSiteLaxMode
+	}
+	return sessions
+}
+
+func (s *sessionCookies) read(ctx *Context) *session {
+	value, err := ctx.Cookie(s.name)
+	if err != nil {
+		return nil
+	}
+	issued, token, found := strings.Cut(value, ".")
+	if !found || token == "" {
+		return nil
+	}
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &session{token: token, issued: time.Unix(unix, 0)}
+}
+
+func (s *sessionCookies) write(ctx *Context, token string) {
+	s.set(ctx, strconv.FormatInt(time.Now().Unix(), 10)+"."+token, int(s.ttl.Seconds()))
+}
+
+func (s *sessionCookies) clear(ctx *Context) {
+	s.set(ctx, "", -1)
+}
+
+func (s *sessionCookies) set(ctx *Context, value string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     s.name,
+		Value:    value,
+		Domain:   s.domain,
+		Path:     s.path,
+		MaxAge:   maxAge,
+		Secure:   !s.insecure,
+		HttpOnly: true,
+		SameSite: s.sameSite,
+	})
+}
+
+// sessionToken returns the token of the cookie as the authorization expects it, JWT are sent with the Bearer scheme.
+func (m *HttpServer) sessionToken(token string) string {
+	if m.authorization.Settings().AuthType == authorities.AuthTypeJwt {
+		return "Bearer " + token
+	}
+	return token
+}
+
+// Login authenticates the browser as the account, a token is generated and set in the session cookie.
+// The token of the previous session of the browser is revoked when the token handler stores them,
+// and the CSRF token is issued again for the new session.
+func (m *HttpServer) Login(ctx *Context, authorized *authorities.Authorized) error {
+	if nil == m.sessions {
+		return errSessionsDisabled
+	}
+	token, err := m.authorization.TokenHandler().GenerateToken(authorized)
+	if err != nil {
+		return err
+	}
+	if previous := m.sessions.read(ctx); nil != previous {
+		m.revokeToken(previous.token)
+	}
+	m.sessions.write(ctx, token)
+	ctx.Set(sessionContextKey, token)
+	ctx.Authorized = authorized
+	ctx.Set(authorizedContextKey, authorized)
+	ctx.CSRFToken()
+	return nil
+}
+
+// Logout clears the session cookie and revokes its token when the token handler stores them,
+// a JWT stays valid until it expires.
+func (m *HttpServer) Logout(ctx *Context) error {
+	if nil == m.sessions {
+		return errSessionsDisabled
+	}
+	current := m.sessions.read(ctx)
+	m.sessions.clear(ctx)
+	if nil == current {
+		return nil
+	}
+	return m.revokeToken(current.token)
+}
+
+func (m *HttpServer) revokeToken(token string) error {
+	if store, ok := m.authorization.TokenHandler().(authorities.TokenStore); ok {
+		return store.RevokeToken(token)
+	}
+	return nil
+}
+
+// renewSession rotates the token of a session older than renew, the rotated token is kept
+// for the grace period so that the requests sent with it in the meantime succeed.
+func (m *HttpServer) renewSession(ctx *Context, current *session, authorized *authorities.Authorized) {
+	if m.sessions.renew <= 0 || time.Since(current.issued) < m.sessions.renew {
+		return
+	}
+	handler := m.authorization.TokenHandler()
+	token, err := handler.GenerateToken(authorized)
+	if err != nil {
+		m.logger.Warn("session token rotation failed", "account", authorized.ID, "err", err)
+		return
+	}
+	m.sessions.write(ctx, token)
+	ctx.Set(sessionContextKey, token)
+	if store, ok := handler.(authorities.TokenStore); ok {
+		if err := store.RenewToken(current.token, m.sessions.grace); err != nil {
+			m.logger.Debug("rotated session token not shortened", "account", authorized.ID, "err", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/option"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+// memoryTokens stores the tokens like the redis token handler
+type memoryTokens struct {
+	tokens map[string]*authorities.Authorized
+	next   int
+}
+
+func (m *memoryTokens) GenerateToken(auth *authorities.Authorized) (string, error) {
+	m.next++
+	token := "token" + strconv.Itoa(m.next)
+	m.tokens[token] = auth
+	return token, nil
+}
+
+func (m *memoryTokens) ParseToken(token string) (*authorities.Authorized, error) {
+	if auth, ok := m.tokens[token]; ok {
+		return auth, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func (m *memoryTokens) RenewToken(token string, timeout time.Duration) error {
+	return nil
+}
+
+func (m *memoryTokens) RevokeToken(token string) error {
+	delete(m.tokens, token)
+	return nil
+}
+
+func TestSessionCookies(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	authorization, _ := authorities.NewAuthorization(&authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, tokens)
+	srv := &HttpServer{
+		logger:        hclog.NewNullLogger(),
+		authorization: authorization,
+		sessions:      newSessionCookies(&option.Session{SameSite: "strict", TTL: 3600, Renew: 60}),
+	}
+	engine := gin.New()
+	engine.POST("/login", func(c *gin.Context) {
+		srv.Login(NewContext(c), authorities.NewAuthorized("1", "alice", nil, nil))
+	})
+	engine.POST("/logout", func(c *gin.Context) {
+		srv.Logout(NewContext(c))
+	})
+	engine.GET("/me", func(c *gin.Context) {
+		ctx := NewContext(c)
+		if err := srv.authenticate(ctx); err != nil {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		ctx.String(http.StatusOK, ctx.Authorized.Account)
+	})
+	send := func(method string, path string, cookie string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if cookie != "" {
+			request.Header.Set("Cookie", cookie)
+		}
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	cookies := send(http.MethodPost, "/login", "").Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected session cookie %v", cookies)
+	}
+	cookie := "session=" + cookies[0].Value
+	if recorder := send(http.MethodGet, "/me", cookie); recorder.Code != http.StatusOK || recorder.Body.String() != "alice" {
+		t.Fatalf("expected the session to be authenticated, got %d", recorder.Code)
+	}
+
+	// a session older than renew gets a new token
+	old := "session=" + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10) + ".token1"
+	recorder := send(http.MethodGet, "/me", old)
+	rotated := recorder.Result().Cookies()
+	if recorder.Code != http.StatusOK || len(rotated) != 1 || !strings.HasSuffix(rotated[0].Value, ".token2") {
+		t.Fatalf("expected the token to be rotated, got %v", rotated)
+	}
+
+	cleared := send(http.MethodPost, "/logout", "session="+rotated[0].Value).Result().Cookies()
+	if len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+		t.Fatalf("expected the cookie to be cleared, got %v", cleared)
+	}
+	if _, ok := tokens.tokens["token2"]; ok {
+		t.Fatal("expected the token to be revoked")
+	}
+	if recorder := send(http.MethodGet, "/me", "session="+rotated[0].Value); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the revoked session to be refused, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"hash"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// signingKey signs the tokens of a feature such as the CSRF tokens, the cursors or the download URLs.
+// A random key is generated when none is configured, the tokens are then only valid on this instance.
+type signingKey struct {
+	key    []byte
+	option string
+	random bool
+	warned sync.Once
+}
+
+// newSigningKey returns the key of the secret, option names the setting of the secret for the warning.
+func newSigningKey(secret string, option string) *signingKey {
+	k := &signingKey{key: []byte(secret), option: option}
+	if len(k.key) == 0 {
+		k.key = make([]byte, 32)
+		rand.Read(k.key)
+		k.random = true
+	}
+	return k
+}
+
+func (k *signingKey) mac() hash.Hash {
+	return hmac.New(sha256.New, k.key)
+}
+
+// warn logs once that the key is random, when the feature is used.
+func (k *signingKey) warn(logger hclog.Logger) {
+	if !k.random {
+		return
+	}
+	k.warned.Do(func() {
+		logger.Warn("signing secret is empty, the signatures of a random key are only valid on this instance", "option", k.option)
+	})
+}
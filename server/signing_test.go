@@ -0,0 +1,30 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestSigningKey(t *testing.T) {
+	var output bytes.Buffer
+	logger := hclog.New(&hclog.LoggerOptions{Output: &output})
+
+	configured := newSigningKey("secret", "http.csrf.secret")
+	configured.warn(logger)
+	if output.Len() != 0 || string(configured.key) != "secret" {
+		t.Fatalf("unexpected warning of a configured key: %s", output.String())
+	}
+
+	random, other := newSigningKey("", "http.download.secret"), newSigningKey("", "http.download.secret")
+	if len(random.key) != 32 || bytes.Equal(random.key, other.key) {
+		t.Fatal("expected a random key per instance")
+	}
+	random.warn(logger)
+	random.warn(logger)
+	if strings.Count(output.String(), "option=http.download.secret") != 1 {
+		t.Fatalf("expected a single warning, got %s", output.String())
+	}
+}
@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	LastEventIDKey = "Last-Event-ID"
+	// AccessTokenQuery carries the token of clients which can't set headers, such as EventSource
+	AccessTokenQuery = "access_token"
+
+	defaultHeartbeat = 15 * time.Second
+)
+
+var ErrStreamClosed = errors.New("stream closed")
+
+// Event is a server-sent event, Data is sent as is when it is a string or []byte, as json otherwise.
+type Event struct {
+	ID    string
+	Event string
+	Data  any
+	// Retry tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+// ReplayBuffer keeps the last events of the streams for the clients resuming with Last-Event-ID.
+type ReplayBuffer interface {
+	// Append stores the event, it sets the ID of the event when empty.
+	Append(ctx context.Context, stream string, event *Event) error
+	// Since returns the events after lastID, or all the events kept when lastID is unknown.
+	Since(ctx context.Context, stream string, lastID string) ([]*Event, error)
+}
+
+type StreamOption func(*Stream)
+
+// StreamHeartbeat sets the interval of the comments keeping the connection alive, 0 disables them.
+func StreamHeartbeat(interval time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.heartbeat = interval
+	}
+}
+
+// StreamReplay replays the events published to the buffer under the name, see PublishEvent,
+// which were missed by a client resuming with Last-Event-ID.
+func StreamReplay(buffer ReplayBuffer, name string) StreamOption {
+	return func(s *Stream) {
+		s.replay = buffer
+		s.name = name
+	}
+}
+
+// StreamRetry sends the reconnection delay to the client when the stream starts.
+func StreamRetry(retry time.Duration) StreamOption {
+	return func(s *Stream) {
+		s.retry = retry
+	}
+}
+
+// Stream writes server-sent events to the client.
+type Stream struct {
+	ctx       *Context
+	request   context.Context
+	rc        *http.ResponseController
+	mutex     sync.Mutex
+	closed    bool
+	done      chan struct{}
+	heartbeat time.Duration
+	retry     time.Duration
+	replay    ReplayBuffer
+	name      string
+	lastID    string
+}
+
+// Stream starts a server-sent events response, the events missed since Last-Event-ID are replayed first.
+// The WriteTimeout of the server no longer bounds the whole response, it bounds each write instead.
+func (c *Context) Stream(opts ...StreamOption) (*Stream, error) {
+	s := &Stream{
+		ctx:       c,
+		request:   c.Request.Context(),
+		rc:        http.NewResponseController(c.Writer),
+		done:      make(chan struct{}),
+		heartbeat: defaultHeartbeat,
+		lastID:    c.GetHeader(LastEventIDKey),
+	}
+	if s.lastID == "" {
+		s.lastID = c.Query("lastEventId")
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	c.stream = s
+
+	if err := s.rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, err
+	}
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	var buf bytes.Buffer
+	if s.retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(s.retry.Milliseconds(), 10) + "\n\n")
+	}
+	if nil != s.replay && s.lastID != "" {
+		events, err := s.replay.Since(s.request, s.name, s.lastID)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if err := encodeEvent(&buf, event); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := s.write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	go s.watch()
+	return s, nil
+}
+
+// watch sends the heartbeats until the client goes away or the stream is closed.
+func (s *Stream) watch() {
+	var tick <-chan time.Time
+	if s.heartbeat > 0 {
+		ticker := time.NewTicker(s.heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.request.Done():
+			s.Close()
+			return
+		case <-s.done:
+			return
+		case <-tick:
+			if err := s.write([]byte(": heartbeat\n\n")); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Done is closed once the client is disconnected or the stream is closed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// LastEventID returns the id of the last event received by the client before reconnecting.
+func (s *Stream) LastEventID() string {
+	return s.lastID
+}
+
+// PublishEvent appends the event to the replay buffer under the name of the stream, once whatever
+// the number of clients. The event returned has the ID given by the buffer and is sent to each client
+// with Stream.Send, so that a client resuming with Last-Event-ID gets the events after it.
+func PublishEvent(ctx context.Context, buffer ReplayBuffer, stream string, event *Event) (*Event, error) {
+	data, err := eventData(event.Data)
+	if err != nil {
+		return nil, err
+	}
+	stored := *event
+	stored.Data = data
+	if err := buffer.Append(ctx, stream, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// Send writes the event to the client, the events to replay are published with PublishEvent first.
+func (s *Stream) Send(event *Event) error {
+	var buf bytes.Buffer
+	if err := encodeEvent(&buf, event); err != nil {
+		return err
+	}
+	return s.write(buf.Bytes())
+}
+
+// SendData writes an unnamed event with the data.
+func (s *Stream) SendData(data any) error {
+	return s.Send(&Event{Data: data})
+}
+
+// Close ends the stream, it is closed anyway once the handler returns.
+func (s *Stream) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+func (s *Stream) write(data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if timeout := s.ctx.writeTimeout; timeout > 0 {
+		_ = s.rc.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	if _, err := s.ctx.Writer.Write(data); err != nil {
+		s.closed = true
+		close(s.done)
+		return err
+	}
+	if err := s.rc.Flush(); err != nil {
+		s.closed = true
+		close(s.done)
+		return err
+	}
+	return nil
+}
+
+func eventData(data any) (string, error) {
+	switch value := data.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case []byte:
+		return string(value), nil
+	}
+	encoded, err := json.Marshal(data)
+	return string(encoded), err
+}
+
+// encodeEvent writes the event in the text/event-stream format, each line of the data in a data field.
+func encodeEvent(buf *bytes.Buffer, event *Event) error {
+	data, err := eventData(event.Data)
+	if err != nil {
+		return err
+	}
+	if event.ID != "" {
+		buf.WriteString("id: " + sanitizeField(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		buf.WriteString("event: " + sanitizeField(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	return nil
+}
+
+func sanitizeField(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+type memoryStream struct {
+	seq    uint64
+	events []*Event
+}
+
+type memoryReplayBuffer struct {
+	mutex   sync.Mutex
+	size    int
+	streams map[string]*memoryStream
+}
+
+// NewMemoryReplayBuffer keeps the last size events of each stream in the process.
+func NewMemoryReplayBuffer(size int) ReplayBuffer {
+	if size <= 0 {
+		size = 100
+	}
+	return &memoryReplayBuffer{size: size, streams: make(map[string]*memoryStream)}
+}
+
+func (m *memoryReplayBuffer) Append(ctx context.Context, stream string, event *Event) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.streams[stream]
+	if !ok {
+		s = &memoryStream{}
+		m.streams[stream] = s
+	}
+	s.seq++
+	if event.ID == "" {
+		event.ID = strconv.FormatUint(s.seq, 10)
+	}
+	s.events = append(s.events, event)
+	if len(s.events) > m.size {
+		s.events = s.events[len(s.events)-m.size:]
+	}
+	return nil
+}
+
+func (m *memoryReplayBuffer) Since(ctx context.Context, stream string, lastID string) ([]*Event, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.streams[stream]
+	if !ok {
+		return nil, nil
+	}
+	for i, event := range s.events {
+		if event.ID == lastID {
+			return append([]*Event(nil), s.events[i+1:]...), nil
+		}
+	}
+	return append([]*Event(nil), s.events...), nil
+}
+
+type redisReplayBuffer struct {
+	redis  redis.UniversalClient
+	prefix string
+	size   int64
+}
+
+// NewRedisReplayBuffer keeps about the last size events of each stream in a redis stream,
+// the ids of the events are the ids of the entries.
+func NewRedisReplayBuffer(client redis.UniversalClient, prefix string, size int64) ReplayBuffer {
+	if prefix == "" {
+		prefix = "sse:"
+	}
+	return &redisReplayBuffer{redis: client, prefix: prefix, size: size}
+}
+
+func (r *redisReplayBuffer) Append(ctx context.Context, stream string, event *Event) error {
+	id, err := r.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.prefix + stream,
+		MaxLen: r.size,
+		Approx: true,
+		Values: map[string]any{"id": event.ID, "event": event.Event, "data": event.Data},
+	}).Result()
+	if err != nil {
+		return err
+	}
+	if event.ID == "" {
+		event.ID = id
+	}
+	return nil
+}
+
+func (r *redisReplayBuffer) Since(ctx context.Context, stream string, lastID string) ([]*Event, error) {
+	messages, err := r.redis.XRange(ctx, r.prefix+stream, "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*Event, 0, len(messages))
+	for _, message := range messages {
+		event := &Event{ID: message.ID}
+		if id, _ := message.Values["id"].(string); id != "" {
+			event.ID = id
+		}
+		event.Event, _ = message.Values["event"].(string)
+		event.Data, _ = message.Values["data"].(string)
+		if event.ID == lastID {
+			events = events[:0]
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// closeStream stops the heartbeats before the gin context is reused.
+func (c *Context) closeStream() {
+	if nil != c.stream {
+		c.stream.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamReplay(t *testing.T) {
+	buffer := NewMemoryReplayBuffer(10)
+	engine := gin.New()
+	engine.GET("/events", func(c *gin.Context) {
+		ctx := NewContext(c)
+		stream, err := ctx.Stream(StreamReplay(buffer, "test"), StreamHeartbeat(0))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if stream.LastEventID() == "" {
+			for _, data := range []string{"one", "two\nlines", "three"} {
+				event, err := PublishEvent(ctx, buffer, "test", &Event{Event: "message", Data: data})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := stream.Send(event); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+		ctx.closeStream()
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	read := func(lastID string) string {
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+		if lastID != "" {
+			request.Header.Set(LastEventIDKey, lastID)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if content := response.Header.Get("Content-Type"); content != "text/event-stream" {
+			t.Fatalf("unexpected content type %s", content)
+		}
+		var lines []string
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	sent := read("")
+	if !strings.Contains(sent, "id: 2\nevent: message\ndata: two\ndata: lines\n") {
+		t.Fatalf("unexpected events:\n%s", sent)
+	}
+
+	replayed := read("1")
+	if strings.Contains(replayed, "data: one") || !strings.Contains(replayed, "id: 3\nevent: message\ndata: three") {
+		t.Fatalf("expected the events after 1 to be replayed:\n%s", replayed)
+	}
+}
+
+func TestPublishEvent(t *testing.T) {
+	buffer := NewMemoryReplayBuffer(10)
+	event, err := PublishEvent(context.Background(), buffer, "test", &Event{Data: map[string]int{"count": 1}})
+	if err != nil || event.ID != "1" || event.Data != `{"count":1}` {
+		t.Fatalf("unexpected event %+v %v", event, err)
+	}
+	// the event published once is sent to each client with the same id
+	for range 2 {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/events", nil)
+		ctx := NewContext(c)
+		stream, err := ctx.Stream(StreamHeartbeat(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(event); err != nil {
+			t.Fatal(err)
+		}
+		ctx.closeStream()
+		if body := recorder.Body.String(); body != "id: 1\ndata: {\"count\":1}\n\n" {
+			t.Fatalf("unexpected event %q", body)
+		}
+	}
+	if events, _ := buffer.Since(context.Background(), "test", ""); len(events) != 1 {
+		t.Fatalf("expected the event to be kept once, got %d", len(events))
+	}
+}
+
+func TestStreamAccessToken(t *testing.T) {
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, nil)
+	srv.Get("/events", &Handler{Stream: true, Func: func(ctx *Context) error {
+		ctx.WriteData("streamed")
+		return nil
+	}})
+	srv.Get("/orders", &Handler{Func: func(ctx *Context) error {
+		ctx.WriteData("orders")
+		return nil
+	}})
+	authorized := func(recorder *httptest.ResponseRecorder) bool {
+		var response Response
+		return json.Unmarshal(recorder.Body.Bytes(), &response) == nil && response.Code == 0
+	}
+
+	if recorder := serve(srv, http.MethodGet, "/events?access_token=token"); !authorized(recorder) {
+		t.Fatalf("expected the token of the query to authorize the stream, got %s", recorder.Body.String())
+	}
+	for _, header := range [][]string{{"Accept", "text/event-stream"}, {"Upgrade", "websocket"}, nil} {
+		if recorder := serve(srv, http.MethodGet, "/orders?access_token=token", header...); authorized(recorder) {
+			t.Fatalf("expected the token of the query to be refused with %v", header)
+		}
+	}
+	if recorder := serve(srv, http.MethodGet, "/orders", AuthorizationKey, "token"); !authorized(recorder) {
+		t.Fatalf("expected the token of the header to authorize, got %s", recorder.Body.String())
+	}
+}
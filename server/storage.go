@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrFileNotFound = &StatusError{Status: http.StatusNotFound, Message: "file not found"}
+	errFileKey      = errors.New("invalid file key")
+)
+
+// FileInfo describes a stored file.
+type FileInfo struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// Checksum is the hex SHA-256 of the content
+	Checksum string    `json:"checksum"`
+	Modified time.Time `json:"modified"`
+}
+
+// Storage stores the uploaded files by key, a key is a slash separated path such as avatars/42.png.
+// An S3 compatible storage implements Put with a streamed upload and Open with ranged reads.
+type Storage interface {
+	// Put stores the content read from r. The info is complete once r was read to the end,
+	// an UploadFile computes the size and the checksum while it streams.
+	Put(ctx context.Context, key string, r io.Reader, info *FileInfo) error
+	// Open returns the content and the info of the file, ErrFileNotFound when there is none.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, *FileInfo, error)
+	// Delete removes the file, ErrFileNotFound when there is none.
+	Delete(ctx context.Context, key string) error
+}
+
+// cleanKey checks that the key is a relative path without dot segments, the files and
+// the infos of the local storage can't be reached out of the root.
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") || path.Clean(key) != key {
+		return "", errFileKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", errFileKey
+		}
+	}
+	return key, nil
+}
+
+// LocalStorage stores the files in a directory, the info of a file is kept beside it in a hidden JSON file.
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{root: root}, nil
+}
+
+func (s *LocalStorage) paths(key string) (string, string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	file := filepath.Join(s.root, filepath.FromSlash(key))
+	return file, filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".json"), nil
+}
+
+// Put writes the content to a temporary file renamed once complete, a failed upload leaves no file.
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, info *FileInfo) error {
+	file, meta, err := s.paths(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := io.Copy(temp, contextReader{ctx: ctx, r: r}); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	info.Key = key
+	info.Modified = time.Now()
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(meta, encoded, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, *FileInfo, error) {
+	file, meta, err := s.paths(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	info := &FileInfo{}
+	if encoded, err := os.ReadFile(meta); err != nil || json.Unmarshal(encoded, info) != nil {
+		// a file copied into the directory has no info
+		info = &FileInfo{Key: key, Name: filepath.Base(file)}
+	}
+	info.Size = stat.Size()
+	info.Modified = stat.ModTime()
+	return f, info, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	file, meta, err := s.paths(key)
+	if err != nil {
+		return err
+	}
+	os.Remove(meta)
+	err = os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrFileNotFound
+	}
+	return err
+}
+
+// contextReader stops reading once the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// MemoryStorage keeps the files in memory, for the tests and a single instance.
+type MemoryStorage struct {
+	mutex sync.RWMutex
+	files map[string]*memoryFile
+}
+
+type memoryFile struct {
+	content []byte
+	info    FileInfo
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]*memoryFile)}
+}
+
+func (s *MemoryStorage) Put(ctx context.Context, key string, r io.Reader, info *FileInfo) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return err
+	}
+	info.Key = key
+	info.Modified = time.Now()
+	s.mutex.Lock()
+	s.files[key] = &memoryFile{content: content, info: *info}
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, *FileInfo, error) {
+	s.mutex.RLock()
+	file, ok := s.files[key]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil, nil, ErrFileNotFound
+	}
+	info := file.info
+	return nopSeekCloser{bytes.NewReader(file.content)}, &info, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.files[key]; !ok {
+		return ErrFileNotFound
+	}
+	delete(s.files, key)
+	return nil
+}
+
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
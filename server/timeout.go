@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepissue/core/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// routeTimeout returns the execution timeout of the route, the one of the server when the handler has none.
+// A negative Handler.Timeout disables it. The streams and the uploads last as long as the client keeps
+// the connection, the timeout of the server doesn't apply to them, only the one of their handler.
+func (m *HttpServer) routeTimeout(handler *Handler) time.Duration {
+	if handler.Timeout != 0 {
+		return max(handler.Timeout, 0)
+	}
+	if handler.Stream || nil != handler.Upload {
+		return 0
+	}
+	return m.handlerTimeout
+}
+
+// timed runs serve with a deadline on the request context, the outbound calls made with the context
+// of the request, see Context.NewRequest, are bounded by it. A 503 Response is sent once the deadline
+// passed unless the handler wrote already, the writes of the handler are discarded then.
+// The gin context is reused once the request returns, so the handler is waited for anyway.
+func (m *HttpServer) timed(ctx *Context, handler *Handler, serve func()) {
+	timeout := m.routeTimeout(handler)
+	if timeout <= 0 {
+		serve()
+		return
+	}
+	deadline, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+	defer cancel()
+	ctx.Request = ctx.Request.WithContext(deadline)
+
+	// the response of the timeout is prepared here, the context belongs to the handler once it runs
+	response := negotiatedRender(ctx.NegotiateFormat(offers...), &Response{
+		Code:      http.StatusServiceUnavailable,
+		Message:   "request timeout",
+		TraceID:   ctx.RequestID(),
+		Timestamp: time.Now().Local().Unix(),
+	})
+	writer := newTimeoutWriter(ctx.Writer)
+	ctx.Writer = writer
+	defer func() {
+		ctx.Writer = writer.ResponseWriter
+	}()
+
+	done := make(chan any, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- &handlerPanic{value: r, stack: utils.PanicStack()}
+				return
+			}
+			done <- nil
+		}()
+		serve()
+	}()
+
+	var panicked any
+	select {
+	case panicked = <-done:
+		writer.finish()
+	case <-deadline.Done():
+		started := time.Now()
+		responded := writer.expire(response)
+		panicked = <-done
+		m.logger.Warn("handler timed out", "path", ctx.FullPath(), "timeout", timeout,
+			"responded", responded, "overrun", time.Since(started))
+	}
+	if nil != panicked {
+		panic(panicked)
+	}
+}
+
+// Deadline returns the deadline of the request, set by the timeout of the route.
+// The gin context has none unless the engine falls back to the request context.
+func (c *Context) Deadline() (time.Time, bool) {
+	return c.Request.Context().Deadline()
+}
+
+// Done is closed once the deadline of the request passed or the client went away.
+func (c *Context) Done() <-chan struct{} {
+	return c.Request.Context().Done()
+}
+
+func (c *Context) Err() error {
+	return c.Request.Context().Err()
+}
+
+// NewRequest returns an outbound request bound to the deadline of the request, carrying its id.
+func (c *Context) NewRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.Request.Context(), method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if id := c.RequestID(); id != "" {
+		req.Header.Set(RequestIDKey, id)
+	}
+	return req, nil
+}
+
+// handlerPanic carries a panic of the handler to the goroutine of the request, with the stack of the handler.
+type handlerPanic struct {
+	value any
+	stack []utils.StackFrame
+}
+
+// timeoutWriter guards the response against the handler still running once the timeout response was written.
+// The handler has its own header map, copied to the response when it writes.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mutex    sync.Mutex
+	header   http.Header
+	timedOut bool
+}
+
+func newTimeoutWriter(w gin.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// sync copies the headers of the handler to the response, the mutex is held.
+func (w *timeoutWriter) sync() {
+	header := w.ResponseWriter.Header()
+	if !w.ResponseWriter.Written() {
+		clear(header)
+		maps.Copy(header, w.header)
+	}
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.sync()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.timedOut {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.timedOut {
+		w.sync()
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.timedOut {
+		w.sync()
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.ResponseWriter.Status()
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.ResponseWriter.Size()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.timedOut || w.ResponseWriter.Written()
+}
+
+// Unwrap gives http.ResponseController the connection, for the write deadlines of the streams.
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish copies the headers set by the handler which returned without writing the body.
+func (w *timeoutWriter) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.timedOut {
+		w.sync()
+	}
+}
+
+// expire writes the timeout response unless the handler wrote already. The response has a length
+// and is flushed as the request only returns with the handler, a compressed one ends with it though.
+func (w *timeoutWriter) expire(response render.Render) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.ResponseWriter.Written() {
+		return false
+	}
+	var body strings.Builder
+	if err := response.Render(&bodyWriter{header: http.Header{}, w: &body}); err != nil {
+		return false
+	}
+	w.timedOut = true
+	// the response has the headers set before the handler ran, the ones of the handler are its own
+	header := w.ResponseWriter.Header()
+	header.Del("Content-Type")
+	response.WriteContentType(w.ResponseWriter)
+	header.Set("Content-Length", strconv.Itoa(body.Len()))
+	header.Set("Cache-Control", "no-store")
+	w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+	w.ResponseWriter.WriteString(body.String())
+	w.ResponseWriter.Flush()
+	return true
+}
+
+// bodyWriter renders a body apart from the response.
+type bodyWriter struct {
+	header http.Header
+	w      io.Writer
+}
+
+func (w *bodyWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bodyWriter) Write(data []byte) (int, error) {
+	return w.w.Write(data)
+}
+
+func (w *bodyWriter) WriteHeader(int) {}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+func TestTimed(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger(), handlerTimeout: 50 * time.Millisecond}
+	crashes := NewCrashReports(1)
+	late := make(chan error, 1)
+	engine := gin.New()
+	engine.Use(Recovery(hclog.NewNullLogger(), crashes))
+	route := func(path string, handler *Handler) {
+		engine.GET(path, func(c *gin.Context) {
+			c.Set(requestIDContextKey, "trace")
+			c.Header("X-Before", "1")
+			ctx := NewContext(c)
+			srv.timed(ctx, handler, func() {
+				srv.handled(ctx, handler.Func(ctx))
+			})
+		})
+	}
+	route("/fast", &Handler{Func: func(ctx *Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			return fmt.Errorf("no deadline")
+		}
+		ctx.Writer.Header().Set("X-Handler", "1")
+		ctx.WriteData("done")
+		return nil
+	}})
+	route("/slow", &Handler{Func: func(ctx *Context) error {
+		ctx.Writer.Header().Set("X-Handler", "1")
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		_, err := ctx.Writer.WriteString("late")
+		late <- err
+		return nil
+	}})
+	route("/unlimited", &Handler{Timeout: -1, Func: func(ctx *Context) error {
+		if _, ok := ctx.Deadline(); ok {
+			return fmt.Errorf("unexpected deadline")
+		}
+		time.Sleep(80 * time.Millisecond)
+		ctx.WriteData("done")
+		return nil
+	}})
+	route("/upstream", &Handler{Timeout: time.Second, Func: func(ctx *Context) error {
+		return fmt.Errorf("upstream: %w", context.DeadlineExceeded)
+	}})
+	route("/panic", &Handler{Func: func(ctx *Context) error {
+		panic("boom")
+	}})
+	send := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	fast := send("/fast")
+	if fast.Code != http.StatusOK || fast.Header().Get("X-Handler") != "1" || fast.Header().Get("X-Before") != "1" {
+		t.Fatalf("unexpected response %d %v %s", fast.Code, fast.Header(), fast.Body.String())
+	}
+
+	slow := send("/slow")
+	var response Response
+	if err := json.Unmarshal(slow.Body.Bytes(), &response); err != nil {
+		t.Fatalf("unexpected body %s", slow.Body.String())
+	}
+	if slow.Code != http.StatusServiceUnavailable || response.Code != http.StatusServiceUnavailable || response.TraceID != "trace" {
+		t.Fatalf("expected the timeout response, got %d %s", slow.Code, slow.Body.String())
+	}
+	if slow.Header().Get("X-Handler") != "" || slow.Header().Get("X-Before") != "1" ||
+		slow.Header().Get("Content-Length") != fmt.Sprint(slow.Body.Len()) {
+		t.Fatalf("unexpected headers %v", slow.Header())
+	}
+	if err := <-late; err != http.ErrHandlerTimeout {
+		t.Fatalf("expected the late write to fail, got %v", err)
+	}
+
+	if unlimited := send("/unlimited"); unlimited.Code != http.StatusOK {
+		t.Fatalf("expected no timeout, got %d %s", unlimited.Code, unlimited.Body.String())
+	}
+	if upstream := send("/upstream"); upstream.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected 504, got %d", upstream.Code)
+	}
+
+	panicked := send("/panic")
+	reports, _ := crashes.Reports()
+	if panicked.Code != http.StatusInternalServerError || len(reports) != 1 || reports[0].Panic != "boom" ||
+		!strings.Contains(reports[0].Stack[0].Function, "TestTimed") {
+		t.Fatalf("expected the panic of the handler reported, got %d %+v", panicked.Code, reports)
+	}
+}
+
+func TestRouteTimeout(t *testing.T) {
+	srv := &HttpServer{handlerTimeout: time.Second}
+	for _, test := range []struct {
+		handler *Handler
+		timeout time.Duration
+	}{
+		{&Handler{}, time.Second},
+		{&Handler{Timeout: time.Minute}, time.Minute},
+		{&Handler{Timeout: -1}, 0},
+		{&Handler{Stream: true}, 0},
+		{&Handler{Upload: &Upload{}}, 0},
+		{&Handler{Stream: true, Timeout: time.Minute}, time.Minute},
+	} {
+		if timeout := srv.routeTimeout(test.handler); timeout != test.timeout {
+			t.Fatalf("expected %v for %+v, got %v", test.timeout, test.handler, timeout)
+		}
+	}
+}
+
+func TestNewRequestDeadline(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(RequestIDKey) != "trace" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	srv := &HttpServer{logger: hclog.NewNullLogger()}
+	handler := &Handler{Timeout: 50 * time.Millisecond, Func: func(ctx *Context) error {
+		req, err := ctx.NewRequest(http.MethodGet, upstream.URL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}}
+	errs := make(chan error, 1)
+	engine := gin.New()
+	engine.GET("/", func(c *gin.Context) {
+		c.Set(requestIDContextKey, "trace")
+		ctx := NewContext(c)
+		srv.timed(ctx, handler, func() {
+			errs <- handler.Func(ctx)
+		})
+	})
+
+	started := time.Now()
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := <-errs; !strings.Contains(fmt.Sprint(err), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected the outbound call to exceed the deadline, got %v", err)
+	}
+	if recorder.Code != http.StatusServiceUnavailable || time.Since(started) > 500*time.Millisecond {
+		t.Fatalf("expected the timeout response, got %d after %v", recorder.Code, time.Since(started))
+	}
+}
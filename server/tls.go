@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/deepissue/core/option"
+	"github.com/deepissue/core/utils"
+	"github.com/hashicorp/go-hclog"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// certificateReloader keeps the certificate and the client CAs up to date with the files,
+// the handshakes in progress keep the previous ones, so no connection is dropped.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   hclog.Logger
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTime     time.Time
+}
+
+func newCertificateReloader(opts *option.TLS, logger hclog.Logger) (*certificateReloader, error) {
+	r := &certificateReloader{
+		certFile: opts.Cert,
+		keyFile:  opts.Key,
+		caFile:   opts.ClientCA,
+		logger:   logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return errors.New("no certificate found in client ca")
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.modTime = r.lastModified()
+	return nil
+}
+
+func (r *certificateReloader) lastModified() time.Time {
+	var last time.Time
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+func (r *certificateReloader) changed() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.lastModified().After(r.modTime)
+}
+
+// watch reloads the files on SIGHUP, and when they change if interval is positive.
+func (r *certificateReloader) watch(ctx context.Context, interval time.Duration) {
+	sighupCh := utils.MakeSighupCh()
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighupCh:
+			r.logger.Info("reloading tls certificate", "reason", "sighup")
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+			r.logger.Info("reloading tls certificate", "reason", "file changed")
+		}
+		if err := r.reload(); err != nil {
+			r.logger.Error("reload tls certificate, keeping the previous one", "err", err)
+			continue
+		}
+		r.logger.Info("tls certificate reloaded", "cert", r.certFile)
+	}
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) ClientCAs() *x509.CertPool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.clientCAs
+}
+
+// newTLSConfig builds the server side tls config, certificates and client CAs come from the reloader.
+func newTLSConfig(opts *option.TLS, reloader *certificateReloader) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[opts.MinVersion]
+	if !ok {
+		if opts.MinVersion != "" {
+			return nil, fmt.Errorf("unsupported tls version: %s", opts.MinVersion)
+		}
+		minVersion = tls.VersionTLS12
+	}
+
+	clientAuth, ok := tlsClientAuths[opts.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("unsupported tls client auth: %s", opts.ClientAuth)
+	}
+	// without client CAs the certificates would be verified against the system roots
+	if (clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert) && opts.ClientCA == "" {
+		return nil, fmt.Errorf("tls client auth %s requires http.tls.client_ca", opts.ClientAuth)
+	}
+
+	var cipherSuites []uint16
+	for _, name := range opts.CipherSuites {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return nil, err
+		}
+		cipherSuites = append(cipherSuites, id)
+	}
+
+	config := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		ClientAuth:     clientAuth,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if opts.ClientCA != "" {
+		config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cloned := config.Clone()
+			cloned.GetConfigForClient = nil
+			cloned.ClientCAs = reloader.ClientCAs()
+			return cloned, nil
+		}
+	}
+	return config, nil
+}
+
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported tls cipher suite: %s", name)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/option"
+	"github.com/hashicorp/go-hclog"
+)
+
+func writeCertificate(t *testing.T, dir string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, dir, 1)
+
+	opts := &option.TLS{
+		Cert:       filepath.Join(dir, "cert.pem"),
+		Key:        filepath.Join(dir, "key.pem"),
+		MinVersion: "1.2",
+	}
+	reloader, err := newCertificateReloader(opts, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := newTLSConfig(opts, reloader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		TLSConfig: config,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	go srv.ServeTLS(ln, "", "")
+	defer srv.Close()
+
+	get := func() *http.Response {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+		res, err := client.Get("https://" + ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	res := get()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", res.Proto)
+	}
+	if serial := res.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+		t.Fatalf("expected serial 1, got %d", serial)
+	}
+
+	writeCertificate(t, dir, 2)
+	if err := reloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if serial := get().TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+		t.Fatalf("expected reloaded serial 2, got %d", serial)
+	}
+
+	if _, err := newTLSConfig(&option.TLS{MinVersion: "0.9"}, reloader); err == nil {
+		t.Fatal("expected unsupported version error")
+	}
+	for _, clientAuth := range []string{"verify_if_given", "require_and_verify"} {
+		if _, err := newTLSConfig(&option.TLS{ClientAuth: clientAuth}, reloader); err == nil {
+			t.Fatalf("expected %s without client ca to be refused", clientAuth)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	uploadContextKey = "core.upload"
+
+	DefaultUploadMaxSize  = 32 << 20
+	DefaultUploadMaxFiles = 10
+	// maxUploadValues bounds the size of the form values sent with the files
+	maxUploadValues = 1 << 20
+)
+
+var (
+	ErrUploadTooLarge = &StatusError{Status: http.StatusRequestEntityTooLarge, Message: "upload too large"}
+	ErrUploadFiles    = &StatusError{Status: http.StatusRequestEntityTooLarge, Message: "too many files"}
+	ErrUploadType     = &StatusError{Status: http.StatusUnsupportedMediaType, Message: "file type not allowed"}
+	ErrNotMultipart   = &StatusError{Status: http.StatusUnsupportedMediaType, Message: "multipart/form-data expected"}
+)
+
+// StatusError is an error returned by a handler with the status of the response.
+type StatusError struct {
+	Status  int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+func (e *StatusError) HTTPStatus() int {
+	return e.Status
+}
+
+// Upload limits the multipart uploads of a route, see Context.Uploads.
+type Upload struct {
+	// Fields are the names of the file fields, any field is accepted when empty
+	Fields []string
+	// MaxSize bounds each file, DefaultUploadMaxSize when 0
+	MaxSize int64
+	// MaxFiles bounds the number of files, DefaultUploadMaxFiles when 0
+	MaxFiles int
+	// Types are the media types allowed, such as image/png or image/*, any type is allowed when empty.
+	// The type is sniffed from the content, the Content-Type sent by the client is ignored.
+	Types []string
+}
+
+func (u *Upload) maxSize() int64 {
+	if u.MaxSize > 0 {
+		return u.MaxSize
+	}
+	return DefaultUploadMaxSize
+}
+
+func (u *Upload) maxFiles() int {
+	if u.MaxFiles > 0 {
+		return u.MaxFiles
+	}
+	return DefaultUploadMaxFiles
+}
+
+func (u *Upload) allowed(contentType string) bool {
+	if len(u.Types) == 0 {
+		return true
+	}
+	media, _, _ := mime.ParseMediaType(contentType)
+	return slices.ContainsFunc(u.Types, func(allowed string) bool {
+		if prefix, found := strings.CutSuffix(allowed, "/*"); found {
+			return strings.HasPrefix(media, prefix+"/")
+		}
+		return strings.EqualFold(allowed, media)
+	})
+}
+
+// limitUpload bounds the body of the routes with Upload to the size of their files.
+func (m *HttpServer) limitUpload(ctx *Context, handler *Handler) {
+	if nil == handler.Upload {
+		return
+	}
+	ctx.Set(uploadContextKey, handler.Upload)
+	limit := handler.Upload.maxSize()*int64(handler.Upload.maxFiles()) + maxUploadValues
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+}
+
+// UploadFile is a file of a multipart upload, its content is read as it streams in.
+// Info is complete once the file was read to the end.
+type UploadFile struct {
+	Field string
+	Info  *FileInfo
+	r     *bufio.Reader
+	hash  hash.Hash
+	limit int64
+}
+
+// Read reads the content, computing the size and the checksum, ErrUploadTooLarge is returned
+// once the content exceeds the size of the route.
+func (f *UploadFile) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	f.hash.Write(p[:n])
+	f.Info.Size += int64(n)
+	if f.Info.Size > f.limit {
+		return n, ErrUploadTooLarge
+	}
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return n, ErrUploadTooLarge
+	}
+	if err == io.EOF {
+		f.Info.Checksum = hex.EncodeToString(f.hash.Sum(nil))
+	}
+	return n, err
+}
+
+// Uploads reads the multipart body of the request, calling fn for each file once its type was
+// sniffed and checked. The files are streamed, fn reads the file or skips it, and the form values
+// are returned. The limits are the ones of the Upload of the route, or the defaults.
+func (c *Context) Uploads(fn func(*UploadFile) error) (url.Values, error) {
+	upload := &Upload{}
+	if value, ok := c.Get(uploadContextKey); ok {
+		upload = value.(*Upload)
+	}
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, ErrNotMultipart
+	}
+	values := url.Values{}
+	files, size := 0, 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, uploadError(err)
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, int64(maxUploadValues-size)+1))
+			if err != nil {
+				return nil, uploadError(err)
+			}
+			if size += len(value); size > maxUploadValues {
+				return nil, ErrUploadTooLarge
+			}
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+		if len(upload.Fields) > 0 && !slices.Contains(upload.Fields, part.FormName()) {
+			continue
+		}
+		if files++; files > upload.maxFiles() {
+			return nil, ErrUploadFiles
+		}
+
+		buffered := bufio.NewReaderSize(part, 512)
+		sniffed, err := buffered.Peek(512)
+		if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, uploadError(err)
+		}
+		file := &UploadFile{
+			Field: part.FormName(),
+			Info:  &FileInfo{Name: filepath.Base(part.FileName()), ContentType: http.DetectContentType(sniffed)},
+			r:     buffered,
+			hash:  sha256.New(),
+			limit: upload.maxSize(),
+		}
+		if !upload.allowed(file.Info.ContentType) {
+			return nil, ErrUploadType
+		}
+		if err := fn(file); err != nil {
+			return nil, uploadError(err)
+		}
+	}
+}
+
+// SaveUploads stores the files of the multipart body under the keys returned by key,
+// the files already stored are deleted when an upload fails.
+func (c *Context) SaveUploads(storage Storage, key func(*UploadFile) string) ([]*FileInfo, url.Values, error) {
+	var saved []*FileInfo
+	values, err := c.Uploads(func(file *UploadFile) error {
+		if err := storage.Put(c.Request.Context(), key(file), file, file.Info); err != nil {
+			return err
+		}
+		saved = append(saved, file.Info)
+		return nil
+	})
+	if err != nil {
+		for _, info := range saved {
+			storage.Delete(c.Request.Context(), info.Key)
+		}
+		return nil, nil, err
+	}
+	return saved, values, nil
+}
+
+// uploadError reports the body exceeding the limit of the route as ErrUploadTooLarge.
+func uploadError(err error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return ErrUploadTooLarge
+	}
+	return err
+}
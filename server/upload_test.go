@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/go-hclog"
+)
+
+var png = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 100)...)
+
+func multipartBody(t *testing.T, files map[string][]byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("title", "avatar")
+	for name, content := range files {
+		part, err := writer.CreateFormFile("image", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func TestUploads(t *testing.T) {
+	srv := &HttpServer{logger: hclog.NewNullLogger()}
+	storage := NewMemoryStorage()
+	handler := &Handler{Upload: &Upload{Fields: []string{"image"}, MaxSize: 1024, MaxFiles: 1, Types: []string{"image/*"}}}
+	engine := gin.New()
+	var saved []*FileInfo
+	engine.POST("/avatars", func(c *gin.Context) {
+		ctx := NewContext(c)
+		srv.limitUpload(ctx, handler)
+		files, values, err := ctx.SaveUploads(storage, func(file *UploadFile) string {
+			return "avatars/" + file.Info.Name
+		})
+		if err == nil && values.Get("title") != "avatar" {
+			t.Errorf("unexpected values %v", values)
+		}
+		saved = files
+		srv.handled(ctx, err)
+	})
+	send := func(files map[string][]byte) int {
+		body, contentType := multipartBody(t, files)
+		request := httptest.NewRequest(http.MethodPost, "/avatars", body)
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := send(map[string][]byte{"me.png": png}); code != http.StatusOK || len(saved) != 1 {
+		t.Fatalf("expected the upload to be saved, got %d", code)
+	}
+	sum := sha256.Sum256(png)
+	if saved[0].ContentType != "image/png" || saved[0].Size != int64(len(png)) || saved[0].Checksum != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected info %+v", saved[0])
+	}
+	file, info, err := storage.Open(context.Background(), "avatars/me.png")
+	if err != nil || info.Checksum != saved[0].Checksum {
+		t.Fatalf("expected the file to be stored, got %v", err)
+	}
+	if content, _ := io.ReadAll(file); !bytes.Equal(content, png) {
+		t.Fatal("unexpected content")
+	}
+
+	if code := send(map[string][]byte{"me.png": []byte("<html><script>alert(1)</script></html>")}); code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected the sniffed type to be refused, got %d", code)
+	}
+	if code := send(map[string][]byte{"big.png": append(png, make([]byte, 1024)...)}); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected the large file to be refused, got %d", code)
+	}
+	if _, _, err := storage.Open(context.Background(), "avatars/big.png"); err != ErrFileNotFound {
+		t.Fatal("expected the large file not to be stored")
+	}
+	if code := send(map[string][]byte{"a.png": png, "b.png": png}); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected the second file to be refused, got %d", code)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := storage.Put(ctx, "docs/a.txt", bytes.NewReader([]byte("hello")), &FileInfo{Name: "a.txt", ContentType: "text/plain"}); err != nil {
+		t.Fatal(err)
+	}
+	file, info, err := storage.Open(ctx, "docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != "hello" || info.Name != "a.txt" || info.ContentType != "text/plain" || info.Size != 5 {
+		t.Fatalf("unexpected file %s %+v", content, info)
+	}
+	for _, key := range []string{"../a.txt", "/etc/passwd", "docs/../../a.txt", "docs/.a.txt.json", ""} {
+		if _, _, err := storage.Open(ctx, key); err != errFileKey {
+			t.Fatalf("expected the key %q to be refused, got %v", key, err)
+		}
+	}
+	if err := storage.Delete(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := storage.Open(ctx, "docs/a.txt"); err != ErrFileNotFound {
+		t.Fatalf("expected the file to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/swaggest/openapi-go/openapi3"
+)
+
+const AcceptVersionKey = "Accept-Version"
+
+// vendorMediaType matches the version of media types such as application/vnd.app.v2+json
+var vendorMediaType = regexp.MustCompile(`^application/vnd\.[\w.-]+?\.(v\d+(?:\.\d+)?)(?:\+[\w-]+)?$`)
+
+// APIVersion is a version of the API, its routes are served under the /{name} prefix,
+// or under the unversioned path when selected by the Accept-Version header or a vendor media type.
+type APIVersion struct {
+	Name        string
+	Deprecation time.Time
+	Sunset      time.Time
+	Link        string
+	Default     bool
+
+	reflector *openapi3.Reflector
+	// routes the unversioned route templates by method, for header and media type selection
+	routes map[string][]string
+}
+
+type VersionOption func(*APIVersion)
+
+// Deprecated emits the Deprecation header on the routes of the version.
+func Deprecated(at time.Time) VersionOption {
+	return func(v *APIVersion) {
+		v.Deprecation = at
+	}
+}
+
+// Sunset emits the Sunset header on the routes of the version.
+func Sunset(at time.Time) VersionOption {
+	return func(v *APIVersion) {
+		v.Sunset = at
+	}
+}
+
+// DeprecationLink links the migration guide of a deprecated version.
+func DeprecationLink(link string) VersionOption {
+	return func(v *APIVersion) {
+		v.Link = link
+	}
+}
+
+// DefaultVersion selects the version for unversioned paths when the request asks for none.
+func DefaultVersion() VersionOption {
+	return func(v *APIVersion) {
+		v.Default = true
+	}
+}
+
+// Version creates a route group of the version, its spec is served at /openapi/{name}.json.
+// Calling it again with the same name returns a group of the same version.
+func (m *HttpServer) Version(name string, opts ...VersionOption) APIHandler {
+	version, ok := m.versions[name]
+	if !ok {
+		reflector := m.newReflector(m.reflector.Spec.Info.Title, name, false)
+		version = &APIVersion{
+			Name:      name,
+			reflector: reflector,
+			routes:    make(map[string][]string),
+		}
+		m.versions[name] = version
+		m.openapi("openapi/"+name+".json", reflector)
+		m.openapi("openapi/"+name+".yaml", reflector)
+	}
+	for _, opt := range opts {
+		opt(version)
+	}
+	if version.Default {
+		m.defaultVersion = name
+	}
+
+	return &RouteGroup{
+		server:     m,
+		prefix:     "/" + name,
+		base:       "/",
+		version:    version,
+		middleware: []gin.HandlerFunc{version.middleware()},
+	}
+}
+
+// middleware emits the deprecation headers of RFC 9745 and RFC 8594.
+func (v *APIVersion) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Set("API-Version", v.Name)
+		if !v.Deprecation.IsZero() {
+			c.Writer.Header().Set("Deprecation", "@"+strconv.FormatInt(v.Deprecation.Unix(), 10))
+		}
+		if !v.Sunset.IsZero() {
+			c.Writer.Header().Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if v.Link != "" {
+			c.Writer.Header().Add("Link", "<"+v.Link+`>; rel="deprecation"`)
+		}
+		c.Next()
+	}
+}
+
+func (v *APIVersion) addRoute(method string, path string) {
+	v.routes[method] = append(v.routes[method], path)
+}
+
+// match reports whether the unversioned path is served by a route of the version.
+func (v *APIVersion) match(method string, path string) bool {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, template := range v.routes[method] {
+		if matchTemplate(strings.Split(strings.Trim(template, "/"), "/"), segments) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchTemplate(template []string, segments []string) bool {
+	for i, part := range template {
+		if strings.HasPrefix(part, "*") {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if !strings.HasPrefix(part, ":") && part != segments[i] {
+			return false
+		}
+	}
+	return len(template) == len(segments)
+}
+
+// requestedVersion returns the version asked by the Accept-Version header or a vendor media type.
+func requestedVersion(r *http.Request) string {
+	if version := strings.TrimSpace(r.Header.Get(AcceptVersionKey)); version != "" {
+		if !strings.HasPrefix(version, "v") {
+			version = "v" + version
+		}
+		return version
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, media := range strings.Split(accept, ",") {
+			media, _, _ = strings.Cut(media, ";")
+			if matches := vendorMediaType.FindStringSubmatch(strings.TrimSpace(media)); matches != nil {
+				return matches[1]
+			}
+		}
+	}
+	return ""
+}
+
+// originalPathKey keeps the path of a request routed to a version, see requestPath
+type originalPathKey struct{}
+
+// requestPath returns the path sent by the client, before it was routed to a version.
+// The logs use it, the authorization matches the routed path, /v1/orders, as the routes are registered.
+func requestPath(r *http.Request) string {
+	if path, ok := r.Context().Value(originalPathKey{}).(string); ok {
+		return path
+	}
+	return r.URL.Path
+}
+
+// ServeHTTP routes the unversioned paths to the version selected by the request, then serves it with gin.
+func (m *HttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(m.versions) > 0 {
+		r = m.rewriteVersion(r)
+	}
+	m.engine.ServeHTTP(w, r)
+}
+
+// rewriteVersion returns the request routed to the version selected, the original path is kept in its context.
+func (m *HttpServer) rewriteVersion(r *http.Request) *http.Request {
+	path, ok := trimPath(r.URL.Path, m.path)
+	if !ok {
+		return r
+	}
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if _, ok := m.versions[first]; ok {
+		return r
+	}
+
+	name := requestedVersion(r)
+	if name == "" {
+		name = m.defaultVersion
+	}
+	version, ok := m.versions[name]
+	if !ok || !version.match(r.Method, path) {
+		return r
+	}
+	r = r.WithContext(context.WithValue(r.Context(), originalPathKey{}, r.URL.Path))
+	r.URL.Path, _ = url.JoinPath("/", m.path, name, path)
+	r.URL.RawPath = ""
+	return r
+}
+
+// trimPath removes the base path from the path, ok is false when the path isn't under it:
+// /apix isn't under /api.
+func trimPath(path string, base string) (string, bool) {
+	base = strings.TrimSuffix(base, "/")
+	trimmed, ok := strings.CutPrefix(path, base)
+	if !ok || (trimmed != "" && !strings.HasPrefix(trimmed, "/")) {
+		return path, false
+	}
+	return trimmed, true
+}
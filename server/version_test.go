@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+)
+
+func TestVersion(t *testing.T) {
+	s := newTestCore(t, "--http.path", "/api", "--http.access.file", "access")
+	srv := newTestHttpServer(t, s, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny, AnonEndpoints: []string{"/v1/orders", "/v2/orders", "/users"}}, nil)
+	deprecation, sunset := time.Unix(1700000000, 0), time.Unix(1800000000, 0)
+	v1 := srv.Version("v1", Deprecated(deprecation), Sunset(sunset), DeprecationLink("https://example.com/migrate"))
+	v2 := srv.Version("v2", DefaultVersion())
+	for name, version := range map[string]APIHandler{"v1": v1, "v2": v2} {
+		version.Get("/orders", &Handler{Func: func(ctx *Context) error {
+			ctx.WriteData(name)
+			return nil
+		}})
+	}
+	v1.Get("/users", &Handler{Func: func(ctx *Context) error {
+		ctx.WriteData("v1")
+		return nil
+	}})
+	served := func(recorder *httptest.ResponseRecorder) string {
+		var response Response
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Code != 0 {
+			return ""
+		}
+		return response.Content.(string)
+	}
+
+	for _, test := range []struct {
+		header  []string
+		version string
+	}{
+		{nil, "v2"},
+		{[]string{AcceptVersionKey, "1"}, "v1"},
+		{[]string{AcceptVersionKey, "v2"}, "v2"},
+		{[]string{"Accept", "text/html, application/vnd.app.v1+json;q=0.9"}, "v1"},
+	} {
+		recorder := serve(srv, http.MethodGet, "/api/orders", test.header...)
+		if version := served(recorder); version != test.version || recorder.Header().Get("API-Version") != test.version {
+			t.Fatalf("expected %v to select %s, got %q %s", test.header, test.version, version, recorder.Body.String())
+		}
+	}
+
+	recorder := serve(srv, http.MethodGet, "/api/orders", AcceptVersionKey, "1")
+	if recorder.Header().Get("Deprecation") != "@"+strconv.FormatInt(deprecation.Unix(), 10) ||
+		recorder.Header().Get("Sunset") != sunset.UTC().Format(http.TimeFormat) ||
+		recorder.Header().Get("Link") != `<https://example.com/migrate>; rel="deprecation"` {
+		t.Fatalf("unexpected deprecation headers %v", recorder.Header())
+	}
+	if recorder := serve(srv, http.MethodGet, "/api/orders"); recorder.Header().Get("Deprecation") != "" || recorder.Header().Get("Sunset") != "" {
+		t.Fatalf("unexpected deprecation headers %v", recorder.Header())
+	}
+
+	// the anonymous endpoints match the routed path, the same whichever way the version is selected
+	for _, test := range []struct {
+		path    string
+		header  []string
+		version string
+	}{
+		{"/api/v1/orders", nil, "v1"},
+		{"/api/orders", []string{AcceptVersionKey, "1"}, "v1"},
+		{"/api/orders", []string{"Accept", "application/vnd.app.v1+json"}, "v1"},
+		// the unversioned anonymous /users doesn't open the route of the version
+		{"/api/v1/users", nil, ""},
+		{"/api/users", []string{AcceptVersionKey, "1"}, ""},
+		{"/api/users", []string{"Accept", "application/vnd.app.v1+json"}, ""},
+		{"/api/users", []string{AcceptVersionKey, "1", AuthorizationKey, "token"}, "v1"},
+	} {
+		if version := served(serve(srv, http.MethodGet, test.path, test.header...)); version != test.version {
+			t.Fatalf("expected %s %v to serve %q, got %q", test.path, test.header, test.version, version)
+		}
+	}
+	if recorder := serve(srv, http.MethodGet, "/apiorders"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("expected a path beside the base path not to be rewritten, got %d", recorder.Code)
+	}
+
+	log, err := os.ReadFile(filepath.Join(s.opts.Log.Path, "test_access.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(log), "route=/api/v2/orders path=/api/orders ") {
+		t.Fatalf("expected the path sent in the access log:\n%s", log)
+	}
+}
+
+func TestTrimPath(t *testing.T) {
+	for _, test := range []struct {
+		path, base, trimmed string
+		ok                  bool
+	}{
+		{"/api/orders", "/api", "/orders", true},
+		{"/api", "/api", "", true},
+		{"/apix/orders", "/api", "/apix/orders", false},
+		{"/orders", "", "/orders", true},
+		{"/orders", "/", "/orders", true},
+	} {
+		if trimmed, ok := trimPath(test.path, test.base); trimmed != test.trimmed || ok != test.ok {
+			t.Fatalf("unexpected trim of %s under %s: %s %v", test.path, test.base, trimmed, ok)
+		}
+	}
+}
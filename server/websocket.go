@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/websocket"
+	"github.com/gin-gonic/gin"
+)
+
+// WSHandler handles the websocket connections of a route, the connection is authorized as a route
+// with the same Policy and Permission before being upgraded.
+type WSHandler struct {
+	Name       string
+	Policy     authorities.AuthorizationPolicy
+	Permission string
+	// RateLimit limits the upgrade requests in addition to the global limit
+	RateLimit *RateLimit
+	// Protocols are the subprotocols of the application accepted besides access_token
+	Protocols []string
+
+	// OnConnect is called once the connection is upgraded and registered, an error closes it
+	OnConnect func(ctx *Context, conn *websocket.Server) error
+	OnText    websocket.Callable
+	OnBinary  websocket.Callable
+	OnClose   websocket.Callable
+}
+
+// Websockets returns the registry of the websocket connections, shared by the http servers.
+func (m *HttpServer) Websockets() *websocket.Registry {
+	return m.websockets
+}
+
+// WebSocket registers a websocket route, the connections are registered until they are closed,
+// with the authorized account attached.
+func (m *HttpServer) WebSocket(path string, handler *WSHandler) {
+	path, _ = url.JoinPath(m.path, path)
+	route := &Handler{
+		Name:       handler.Name,
+		RateLimit:  handler.RateLimit,
+		Policy:     handler.Policy,
+		Permission: handler.Permission,
+	}
+
+	m.engine.GET(path, func(c *gin.Context) {
+		ctx := NewContext(c)
+		ctx.Set(queryTokenContextKey, true)
+		if m.rateLimited(ctx, route, false) {
+			return
+		}
+		if m.crossSiteUpgrade(ctx) {
+			ctx.Negotiate(http.StatusForbidden, &Response{
+				Code:      http.StatusForbidden,
+				Message:   errCSRFOrigin.Error(),
+				Timestamp: time.Now().Local().Unix(),
+			})
+			return
+		}
+		if err := m.authorize(ctx, route); err != nil {
+			ctx.WriteFail(401, err.Error())
+			return
+		}
+		if !m.permitted(ctx, route) {
+			ctx.WriteFail(403, "permission denied")
+			return
+		}
+		if m.rateLimited(ctx, route, true) {
+			return
+		}
+
+		protocols := handler.Protocols
+		if ctx.GetHeader(AuthorizationKey) == "" && ctx.Query(AccessTokenQuery) == "" {
+			protocols = append(protocols[:len(protocols):len(protocols)], AccessTokenQuery)
+		}
+		conn, err := websocket.UpgradeHTTP(m.ctx, c.Request, c.Writer, m.logger, protocols...)
+		if err != nil {
+			m.logger.Warn("websocket upgrade failed", "path", path, "remote", ctx.RemoteAddr, "err", err)
+			return
+		}
+		conn.Connection().SetAuthorized(ctx.Authorized)
+		conn.Track(m.websockets)
+		defer conn.Close()
+
+		conn.OnText(handler.OnText)
+		conn.OnBinary(handler.OnBinary)
+		conn.OnClose(handler.OnClose)
+		conn.OnPanic(m.websocketRecovery(ctx))
+		if nil != handler.OnConnect {
+			if err := handler.OnConnect(ctx, conn); err != nil {
+				m.logger.Warn("websocket connection rejected", "path", path, "remote", ctx.RemoteAddr, "err", err)
+				return
+			}
+		}
+		// the request is served until the connection ends, so the access log covers it
+		if err := conn.HandleConnection(); err != nil {
+			m.logger.Debug("websocket connection ended", "path", path, "remote", ctx.RemoteAddr, "err", err)
+		}
+	})
+}
+
+// crossSiteUpgrade reports a handshake carrying cookies but no token from an origin not trusted.
+// The browsers send the cookies with the websocket handshakes of any site and the CSRF tokens
+// can't be sent, so the session cookie and the cookies read by OnConnect are only trusted by origin.
+func (m *HttpServer) crossSiteUpgrade(ctx *Context) bool {
+	if len(ctx.Request.Cookies()) == 0 || accessToken(ctx) != "" {
+		return false
+	}
+	return !m.trustedOrigin(ctx)
+}
+
+// websocketProtocols returns the subprotocols offered by the client in Sec-WebSocket-Protocol.
+func websocketProtocols(ctx *Context) []string {
+	var protocols []string
+	for _, header := range ctx.Request.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepissue/core/authorities"
+	"github.com/deepissue/core/websocket"
+	"github.com/gobwas/ws"
+)
+
+// dialWebSocket opens a websocket on the server, the header values follow the protocols.
+func dialWebSocket(server *httptest.Server, path string, protocols []string, header ...string) (ws.Handshake, error) {
+	values := http.Header{}
+	for i := 0; i+1 < len(header); i += 2 {
+		values.Set(header[i], header[i+1])
+	}
+	dialer := ws.Dialer{Header: ws.HandshakeHeaderHTTP(values), Protocols: protocols}
+	conn, _, handshake, err := dialer.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+path)
+	if err == nil {
+		conn.Close()
+	}
+	return handshake, err
+}
+
+func TestWebSocketCrossSite(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, tokens,
+		"--http.session", "--http.session.insecure")
+	srv.WebSocket("/events", &WSHandler{Name: "events"})
+	server := httptest.NewServer(srv)
+	defer server.Close()
+	token, _ := tokens.GenerateToken(authorities.NewAuthorized("1", "alice", nil, nil))
+	cookie := "session=" + strconv.FormatInt(time.Now().Unix(), 10) + "." + token
+
+	if _, err := dialWebSocket(server, "/events", nil, "Cookie", cookie, "Origin", "https://evil.com"); err == nil {
+		t.Fatal("expected the cross-site handshake authenticated by cookie to be refused")
+	}
+	if _, err := dialWebSocket(server, "/events", nil, "Cookie", cookie, "Origin", server.URL); err != nil {
+		t.Fatalf("expected the same origin handshake to be accepted, got %v", err)
+	}
+	if _, err := dialWebSocket(server, "/events", nil, "Cookie", "theme=dark", AuthorizationKey, token, "Origin", "https://evil.com"); err != nil {
+		t.Fatalf("expected the handshake with a token to be accepted from any origin, got %v", err)
+	}
+}
+
+func TestWebSocketAuth(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, tokens)
+	srv.WebSocket("/chat", &WSHandler{Name: "chat", Protocols: []string{"chat.v1"}})
+	srv.WebSocket("/events", &WSHandler{Name: "events"})
+	server := httptest.NewServer(srv)
+	defer server.Close()
+	token, _ := tokens.GenerateToken(authorities.NewAuthorized("1", "alice", nil, nil))
+
+	if _, err := dialWebSocket(server, "/chat", nil); err == nil {
+		t.Fatal("expected the handshake without token to be refused")
+	}
+	if _, err := dialWebSocket(server, "/chat", nil, AuthorizationKey, "invalid"); err == nil {
+		t.Fatal("expected the handshake with an invalid token to be refused")
+	}
+	for _, test := range []struct {
+		path      string
+		protocols []string
+		header    []string
+		selected  string
+	}{
+		{"/chat", nil, []string{AuthorizationKey, token}, ""},
+		{"/chat?" + AccessTokenQuery + "=" + token, []string{"chat.v1"}, nil, "chat.v1"},
+		{"/chat", []string{"chat.v1", AccessTokenQuery, token}, nil, "chat.v1"},
+		// browsers need one of the protocols offered to be selected
+		{"/events", []string{AccessTokenQuery, token}, nil, AccessTokenQuery},
+	} {
+		handshake, err := dialWebSocket(server, test.path, test.protocols, test.header...)
+		if err != nil || handshake.Protocol != test.selected {
+			t.Fatalf("expected %s with %v %v to be accepted, got %q %v", test.path, test.protocols, test.header, handshake.Protocol, err)
+		}
+	}
+}
+
+func TestWebSocketRegistry(t *testing.T) {
+	tokens := &memoryTokens{tokens: make(map[string]*authorities.Authorized)}
+	srv := newTestServer(t, &authorities.Settings{DefaultPolicy: authorities.AuthorizationPolicyDeny}, tokens)
+	connected := make(chan string, 1)
+	srv.WebSocket("/events", &WSHandler{Name: "events", OnConnect: func(ctx *Context, conn *websocket.Server) error {
+		connected <- conn.Connection().ID()
+		return nil
+	}})
+	server := httptest.NewServer(srv)
+	defer server.Close()
+	token, _ := tokens.GenerateToken(authorities.NewAuthorized("1", "alice", nil, nil))
+
+	dialer := ws.Dialer{Header: ws.HandshakeHeaderHTTP(http.Header{AuthorizationKey: {token}})}
+	conn, _, _, err := dialer.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+"/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := <-connected
+	registered := srv.Websockets().Get(id)
+	if nil == registered || registered.Authorized() == nil || registered.Authorized().Account != "alice" ||
+		len(srv.Websockets().ByAccount("1")) != 1 {
+		t.Fatalf("expected the connection registered with its account, got %+v", registered)
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(time.Second); srv.Websockets().Len() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the closed connection to be removed")
+		}
+	}
+}
@@ -36,6 +36,8 @@ func Download(url string) ([]byte, error) {
 	return data, err
 }
 
+// PerformHTTPRequest sends the request until it succeeds, the retries stop with the context of the request,
+// such as the deadline of the server.Context the request was created by.
 func PerformHTTPRequest(req *http.Request, retryCounts ...int) (*http.Response, error) {
 
 	// 设置重试次数
@@ -51,16 +53,22 @@ func PerformHTTPRequest(req *http.Request, retryCounts ...int) (*http.Response,
 			// 请求成功，返回响应
 			return resp, nil
 		}
-		resp.Body.Close()
-		// 如果不是最后一次重试，等待一段时间后重试
+		if resp != nil {
+			resp.Body.Close()
+		}
+		// 如果不是最后一次重试，等待一段时间后重试，请求的 context 结束时不再重试
 		if i < retryCount-1 {
-			time.Sleep(300 * time.Millisecond)
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(300 * time.Millisecond):
+			}
 		}
 	}
 
 	// 所有重试都失败，返回带有 HTTP 状态码的错误消息
 	if err != nil {
-		return nil, fmt.Errorf("failed after %d attempts. Last error: %v", retryCount, err)
+		return nil, fmt.Errorf("failed after %d attempts. Last error: %w", retryCount, err)
 	}
 
 	return nil, fmt.Errorf("failed after %d attempts. (HTTP Status Code: %d)", retryCount, resp.StatusCode)
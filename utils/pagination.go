@@ -8,20 +8,33 @@ package utils
 
 // Pagination 返回分页数据结构
 type Pagination struct {
-	Page       int `json:"page"`
-	Size       int `json:"size"`
-	Total      int `json:"total"`
-	TotalPages int `json:"total_pages"`
+	Page       int  `json:"page" xml:"page"`
+	Size       int  `json:"size" xml:"size"`
+	Total      int  `json:"total" xml:"total"`
+	TotalPages int  `json:"total_pages" xml:"total_pages"`
+	HasMore    bool `json:"has_more" xml:"has_more"`
 }
 
+// CursorPagination is the pagination of a keyset page, Next and Previous are the cursors
+// of the pages around it, empty at the ends.
+type CursorPagination struct {
+	Size     int    `json:"size" xml:"size"`
+	HasMore  bool   `json:"has_more" xml:"has_more"`
+	Next     string `json:"next" xml:"next"`
+	Previous string `json:"previous" xml:"previous"`
+}
+
+// NewPagination returns the offset pagination of the page, a size of 0 or less is a single page.
 func NewPagination(total int64, size int64, page int) *Pagination {
-	mod := total % size
-	totalPages := total / size
-	if 0 == totalPages {
-		totalPages = 1
+	totalPages := int64(1)
+	if size > 0 && total > size {
+		totalPages = (total + size - 1) / size
 	}
-	if mod > 0 && total > size {
-		totalPages += 1
+	return &Pagination{
+		Total:      int(total),
+		TotalPages: int(totalPages),
+		Page:       page,
+		Size:       int(size),
+		HasMore:    int64(page) < totalPages,
 	}
-	return &Pagination{Total: int(total), TotalPages: int(totalPages), Page: page, Size: int(size)}
 }
@@ -0,0 +1,23 @@
+package utils
+
+import "testing"
+
+func TestNewPagination(t *testing.T) {
+	for _, test := range []struct {
+		total, size int64
+		page        int
+		pages       int
+		more        bool
+	}{
+		{0, 20, 1, 1, false},
+		{20, 20, 1, 1, false},
+		{21, 20, 1, 2, true},
+		{45, 20, 3, 3, false},
+		{10, 0, 1, 1, false},
+	} {
+		pagination := NewPagination(test.total, test.size, test.page)
+		if pagination.TotalPages != test.pages || pagination.HasMore != test.more {
+			t.Fatalf("unexpected pagination of %+v: %+v", test, pagination)
+		}
+	}
+}
@@ -21,6 +21,24 @@ func MakeShutdownCh() chan struct{} {
 	return resultCh
 }
 
+// MakeGracefulShutdownCh returns two channels for a graceful shutdown. The first
+// one is closed on the first SIGINT or SIGTERM received, the second one is closed
+// on the next, so that a stuck shutdown can be forced to exit.
+func MakeGracefulShutdownCh() (chan struct{}, chan struct{}) {
+	shutdownCh := make(chan struct{})
+	forceCh := make(chan struct{})
+
+	signalCh := make(chan os.Signal, 2)
+	signal.Notify(signalCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signalCh
+		close(shutdownCh)
+		<-signalCh
+		close(forceCh)
+	}()
+	return shutdownCh, forceCh
+}
+
 // MakeSighupCh returns a channel that can be used for SIGHUP
 // reloading. This channel will send a message for every
 // SIGHUP received.
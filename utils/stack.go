@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// StackFrame is a frame of a goroutine stack
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+func (f StackFrame) String() string {
+	return fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line)
+}
+
+// PanicStack returns the stack of the goroutine from the frame which panicked,
+// it is meant to be called by the deferred function recovering the panic.
+func PanicStack() []StackFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []StackFrame
+	panicked := false
+	for {
+		frame, more := frames.Next()
+		if frame.Function == "runtime.gopanic" {
+			// the frames above are the deferred function and the runtime
+			stack = stack[:0]
+			panicked = true
+		} else if panicked || !strings.HasPrefix(frame.Function, "runtime.") {
+			stack = append(stack, StackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
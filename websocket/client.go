@@ -104,11 +104,32 @@ func (c *Client) OnClose(callback Callable) {
 	}
 }
 
+func (c *Client) OnPanic(callback PanicHandler) {
+	if c.handler != nil {
+		c.handler.OnPanic(callback)
+	}
+}
+
 // Connect 连接服务器
 func (c *Client) Connect() error {
 	return c.connect()
 }
 
+// Start 以自动重连方式在后台连接服务器，供 server.Component 使用
+func (c *Client) Start(ctx context.Context) error {
+	go func() {
+		if err := c.ConnectWithReconnect(); err != nil && c.ctx.Err() == nil {
+			c.logger.Error("connect with reconnect", "addr", c.addr, "error", err)
+		}
+	}()
+	return nil
+}
+
+// Stop 关闭客户端，供 server.Component 使用
+func (c *Client) Stop(ctx context.Context) error {
+	return c.Close()
+}
+
 // WaitConnected 等待连接建立
 func (c *Client) WaitConnected(timeout time.Duration) error {
 	if c.GetState() == StateConnected {
@@ -249,8 +270,6 @@ func (c *Client) SendBinary(data []byte) error {
 }
 
 func (c *Client) IsConnected() bool {
-	c.stateMutex.RLock()
-	defer c.stateMutex.RUnlock()
 	return c.GetState() == StateConnected
 }
 
@@ -7,8 +7,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/deepissue/core/authorities"
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
+	"github.com/google/uuid"
 )
 
 // WSConnection 封装 WebSocket 连接
@@ -26,6 +28,10 @@ type WSConnection struct {
 	closed    chan struct{}
 
 	remoteAddr string
+
+	// 连接标识与认证信息
+	id         string
+	authorized *authorities.Authorized
 }
 
 // NewWSConnection 创建 WebSocket 连接包装器
@@ -37,9 +43,25 @@ func NewWSConnection(ctx context.Context, conn net.Conn, side ws.State) *WSConne
 		reader:     wsutil.NewReader(conn, side),
 		closed:     make(chan struct{}),
 		remoteAddr: conn.RemoteAddr().String(),
+		id:         uuid.NewString(),
 	}
 }
 
+// ID 获取连接标识
+func (wc *WSConnection) ID() string {
+	return wc.id
+}
+
+// Authorized 获取连接的认证账户，未认证时为 nil
+func (wc *WSConnection) Authorized() *authorities.Authorized {
+	return wc.authorized
+}
+
+// SetAuthorized 设置连接的认证账户，需在加入注册表之前调用
+func (wc *WSConnection) SetAuthorized(authorized *authorities.Authorized) {
+	wc.authorized = authorized
+}
+
 // ReadMessage 读取消息
 func (wc *WSConnection) ReadMessage() (ws.OpCode, []byte, error) {
 	hdr, err := wc.readFrameWithTimeout()
@@ -72,6 +94,11 @@ func (wc *WSConnection) WriteMessage(opCode ws.OpCode, data []byte) error {
 	}
 }
 
+// WriteClose 发送关闭帧
+func (wc *WSConnection) WriteClose(code ws.StatusCode, reason string) error {
+	return wc.WriteMessage(ws.OpClose, ws.NewCloseFrameBody(code, reason))
+}
+
 // Close 关闭连接
 func (wc *WSConnection) Close() error {
 	var err error
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/deepissue/core/utils"
 	"github.com/gobwas/ws"
 	"github.com/hashicorp/go-hclog"
 )
@@ -28,6 +30,7 @@ type Handler struct {
 	onText   Callable
 	onBinary Callable
 	onClose  Callable
+	onPanic  PanicHandler
 
 	// ping/pong 管理
 	pingInterval time.Duration
@@ -56,6 +59,9 @@ func (h *Handler) OnText(callback Callable)   { h.onText = callback }
 func (h *Handler) OnBinary(callback Callable) { h.onBinary = callback }
 func (h *Handler) OnClose(callback Callable)  { h.onClose = callback }
 
+// OnPanic 设置 panic 回调，panic 总会被记录到日志
+func (h *Handler) OnPanic(callback PanicHandler) { h.onPanic = callback }
+
 // SetPingInterval 设置 ping 间隔
 func (h *Handler) SetPingInterval(interval time.Duration) {
 	h.pingInterval = interval
@@ -63,11 +69,12 @@ func (h *Handler) SetPingInterval(interval time.Duration) {
 }
 
 // HandleConnection 处理连接生命周期
-func (h *Handler) HandleConnection(conn *WSConnection) error {
+func (h *Handler) HandleConnection(conn *WSConnection) (err error) {
 	defer func() {
 		h.logger.Trace("stop handling connection", "side", sides[h.side], "remote", conn.RemoteAddr())
 		if r := recover(); r != nil {
-			h.logger.Error("panic recovered in HandleConnection", sides[h.side], "remote", conn.RemoteAddr(), "error", r)
+			h.recovered(conn, r, utils.PanicStack())
+			err = fmt.Errorf("panic: %v", r)
 		}
 	}()
 	h.logger.Trace("start handling connection", "side", sides[h.side], "remote", conn.RemoteAddr())
@@ -170,8 +177,26 @@ func (h *Handler) handleMessage(conn *WSConnection, opCode ws.OpCode, data []byt
 	return nil
 }
 
+// recovered 记录 panic 并通知回调
+func (h *Handler) recovered(conn *WSConnection, r any, stack []utils.StackFrame) {
+	frames := make([]string, 0, len(stack))
+	for _, frame := range stack {
+		frames = append(frames, frame.String())
+	}
+	h.logger.Error("panic recovered", "side", sides[h.side], "remote", conn.RemoteAddr(),
+		"panic", r, "stack", frames)
+	if h.onPanic != nil {
+		h.onPanic(conn, r, stack)
+	}
+}
+
 // pingLoop ping 循环（仅客户端）
 func (h *Handler) pingLoop(conn *WSConnection) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.recovered(conn, r, utils.PanicStack())
+		}
+	}()
 	ticker := time.NewTicker(h.pingInterval)
 	defer ticker.Stop()
 
@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/gobwas/ws"
+)
+
+// Registry 连接注册表，用于跟踪服务端的活动连接
+type Registry struct {
+	mutex       sync.RWMutex
+	connections map[string]*WSConnection
+	// accounts 按认证账户索引连接
+	accounts map[string]map[string]*WSConnection
+}
+
+// NewRegistry 创建连接注册表
+func NewRegistry() *Registry {
+	return &Registry{
+		connections: make(map[string]*WSConnection),
+		accounts:    make(map[string]map[string]*WSConnection),
+	}
+}
+
+// Add 注册连接，已认证的连接同时按账户索引
+func (r *Registry) Add(conn *WSConnection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.connections[conn.ID()] = conn
+	if account := accountOf(conn); account != "" {
+		if r.accounts[account] == nil {
+			r.accounts[account] = make(map[string]*WSConnection)
+		}
+		r.accounts[account][conn.ID()] = conn
+	}
+}
+
+// Remove 移除连接
+func (r *Registry) Remove(conn *WSConnection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.connections, conn.ID())
+	if account := accountOf(conn); account != "" {
+		delete(r.accounts[account], conn.ID())
+		if len(r.accounts[account]) == 0 {
+			delete(r.accounts, account)
+		}
+	}
+}
+
+// Get 按连接标识查找连接
+func (r *Registry) Get(id string) *WSConnection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.connections[id]
+}
+
+// ByAccount 查找账户的所有连接
+func (r *Registry) ByAccount(account string) []*WSConnection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	connections := make([]*WSConnection, 0, len(r.accounts[account]))
+	for _, conn := range r.accounts[account] {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// Len 活动连接数
+func (r *Registry) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.connections)
+}
+
+// Range 遍历连接，callback 返回 false 时停止
+func (r *Registry) Range(callback func(conn *WSConnection) bool) {
+	r.mutex.RLock()
+	connections := make([]*WSConnection, 0, len(r.connections))
+	for _, conn := range r.connections {
+		connections = append(connections, conn)
+	}
+	r.mutex.RUnlock()
+
+	for _, conn := range connections {
+		if !callback(conn) {
+			return
+		}
+	}
+}
+
+// CloseAll 向所有连接发送关闭帧并关闭连接
+func (r *Registry) CloseAll(code ws.StatusCode, reason string) {
+	r.Range(func(conn *WSConnection) bool {
+		conn.WriteClose(code, reason)
+		conn.Close()
+		r.Remove(conn)
+		return true
+	})
+}
+
+func accountOf(conn *WSConnection) string {
+	if conn.Authorized() == nil {
+		return ""
+	}
+	return string(conn.Authorized().ID)
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"context"
 	"net/http"
+	"slices"
 
 	"github.com/deepissue/core/utils"
 	"github.com/gobwas/ws"
@@ -15,13 +16,20 @@ type Server struct {
 	connection *WSConnection
 	ctx        context.Context
 	logger     hclog.Logger
+	registry   *Registry
 }
 
-// UpgradeHTTP 从 HTTP 升级到 WebSocket
+// UpgradeHTTP 从 HTTP 升级到 WebSocket，protocols 为可接受的子协议，按客户端提供的顺序选择第一个
 func UpgradeHTTP(ctx context.Context, request *http.Request,
-	writer http.ResponseWriter, logger hclog.Logger) (*Server, error) {
+	writer http.ResponseWriter, logger hclog.Logger, protocols ...string) (*Server, error) {
 
-	conn, _, _, err := ws.UpgradeHTTP(request, writer)
+	upgrader := ws.HTTPUpgrader{}
+	if len(protocols) > 0 {
+		upgrader.Protocol = func(protocol string) bool {
+			return slices.Contains(protocols, protocol)
+		}
+	}
+	conn, _, _, err := upgrader.Upgrade(request, writer)
 	if err != nil {
 		return nil, err
 	}
@@ -42,21 +50,36 @@ func UpgradeHTTP(ctx context.Context, request *http.Request,
 }
 
 // 消息回调代理
-func (s *Server) OnPing(callback Callable)   { s.handler.OnPing(callback) }
-func (s *Server) OnPong(callback Callable)   { s.handler.OnPong(callback) }
-func (s *Server) OnText(callback Callable)   { s.handler.OnText(callback) }
-func (s *Server) OnBinary(callback Callable) { s.handler.OnBinary(callback) }
-func (s *Server) OnClose(callback Callable)  { s.handler.OnClose(callback) }
+func (s *Server) OnPing(callback Callable)      { s.handler.OnPing(callback) }
+func (s *Server) OnPong(callback Callable)      { s.handler.OnPong(callback) }
+func (s *Server) OnText(callback Callable)      { s.handler.OnText(callback) }
+func (s *Server) OnBinary(callback Callable)    { s.handler.OnBinary(callback) }
+func (s *Server) OnClose(callback Callable)     { s.handler.OnClose(callback) }
+func (s *Server) OnPanic(callback PanicHandler) { s.handler.OnPanic(callback) }
 
 // 发送消息方法
 func (s *Server) SendText(data []byte) error   { return s.connection.WriteMessage(ws.OpText, data) }
 func (s *Server) SendBinary(data []byte) error { return s.connection.WriteMessage(ws.OpBinary, data) }
 
+// Track 将连接加入注册表，连接结束时自动移除
+func (s *Server) Track(registry *Registry) {
+	s.registry = registry
+	registry.Add(s.connection)
+}
+
 // HandleConnection 处理连接
 func (s *Server) HandleConnection() error {
+	if s.registry != nil {
+		defer s.registry.Remove(s.connection)
+	}
 	return s.handler.HandleConnection(s.connection)
 }
 
+// Connection 获取底层连接
+func (s *Server) Connection() *WSConnection {
+	return s.connection
+}
+
 // RemoteAddr 获取远程地址
 func (s *Server) RemoteAddr() string {
 	return s.connection.RemoteAddr()
@@ -64,6 +87,9 @@ func (s *Server) RemoteAddr() string {
 
 // Close 关闭连接
 func (s *Server) Close() error {
+	if s.registry != nil {
+		s.registry.Remove(s.connection)
+	}
 	s.handler.Stop()
 	return s.connection.Close()
 }
@@ -2,6 +2,8 @@ package websocket
 
 import (
 	"time"
+
+	"github.com/deepissue/core/utils"
 )
 
 // 连接状态
@@ -37,6 +39,9 @@ type ReconnectConfig struct {
 // 回调函数类型
 type Callable func(conn *WSConnection, buffer []byte)
 
+// PanicHandler 连接处理发生 panic 时的回调，stack 从 panic 处开始
+type PanicHandler func(conn *WSConnection, recovered any, stack []utils.StackFrame)
+
 // 默认重连配置
 var DefaultReconnectConfig = ReconnectConfig{
 	Enable:          true,